@@ -0,0 +1,149 @@
+package kvraft
+
+import (
+	"crypto/rand"
+	"hadoop-raft/labrpc"
+	"hadoop-raft/raft"
+	"math/big"
+	"time"
+)
+
+type Clerk struct {
+	servers []*labrpc.ClientEnd
+	// You will have to modify this struct.
+	clientId int64 // client会话id
+	seq      int64 // 最近一次使用的请求序号
+	leader   int   // 最近一次成功请求的leader，下次优先发送给它
+}
+
+func nrand() int64 {
+	max := big.NewInt(int64(1) << 62)
+	bigx, _ := rand.Int(rand.Reader, max)
+	x := bigx.Int64()
+	return x
+}
+
+func MakeClerk(servers []*labrpc.ClientEnd) *Clerk {
+	ck := new(Clerk)
+	ck.servers = servers
+	// You'll have to add code here.
+	ck.clientId = nrand()
+	ck.leader = 0
+	return ck
+}
+
+// leader返回ErrOverloaded之后，等待这么久再重试
+const overloadBackoff = 50 * time.Millisecond
+
+// nextServer 根据回复中的leader提示选择下一个要尝试的节点，没有提示时轮询
+func (ck *Clerk) nextServer(leaderId int) {
+	if leaderId != raft.NoLeader && leaderId != ck.leader && leaderId < len(ck.servers) {
+		ck.leader = leaderId
+	} else {
+		ck.leader = (ck.leader + 1) % len(ck.servers)
+	}
+}
+
+//
+// fetch the current value for a key.
+// returns "" if the key does not exist.
+// keeps trying forever in the face of all other errors.
+//
+// you can send an RPC with code like this:
+// ok := ck.servers[i].Call("KVServer.Get", &args, &reply)
+//
+// the types of args and reply (including whether they are pointers)
+// must match the declared types of the RPC handler function's
+// arguments. and reply must be passed as a pointer.
+//
+func (ck *Clerk) Get(key string) string {
+	value, _ := ck.GetWithin(key, 0)
+	return value
+}
+
+// GetWithin 与Get相同，但最多重试timeout时长，timeout为0表示一直重试。
+// 超时前最后一次被leader以ErrOverloaded拒绝时返回ErrOverloaded，命令过大时立即返回ErrTooLarge
+func (ck *Clerk) GetWithin(key string, timeout time.Duration) (string, Err) {
+	// You will have to modify this function.
+	ck.seq++
+	args := GetArgs{Key: key, ClientId: ck.clientId, Seq: ck.seq}
+	t0 := time.Now()
+	var last Err = ErrTimeout
+	for timeout == 0 || time.Since(t0) < timeout {
+		var reply GetReply
+		ok := ck.servers[ck.leader].Call("KVServer.Get", &args, &reply)
+		if ok && reply.Err == ErrOverloaded {
+			//leader拒绝了命令，等已有的日志提交后再向它重试
+			last = ErrOverloaded
+			time.Sleep(overloadBackoff)
+			continue
+		}
+		if ok && !reply.WrongLeader && reply.Err != ErrTimeout {
+			return reply.Value, reply.Err
+		}
+		last = ErrTimeout
+		leaderId := raft.NoLeader
+		if ok {
+			leaderId = reply.LeaderId
+		}
+		ck.nextServer(leaderId)
+		if !ok || leaderId == raft.NoLeader {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return "", last
+}
+
+//
+// shared by Put and Append.
+//
+// you can send an RPC with code like this:
+// ok := ck.servers[i].Call("KVServer.PutAppend", &args, &reply)
+//
+// the types of args and reply (including whether they are pointers)
+// must match the declared types of the RPC handler function's
+// arguments. and reply must be passed as a pointer.
+//
+func (ck *Clerk) PutAppend(key string, value string, op string) {
+	ck.PutAppendWithin(key, value, op, 0)
+}
+
+// PutAppendWithin 与PutAppend相同，但最多重试timeout时长，timeout为0表示一直重试。
+// 所有重试都使用同一个序号，所以请求最多只会生效一次。错误的含义与GetWithin相同
+func (ck *Clerk) PutAppendWithin(key string, value string, op string, timeout time.Duration) Err {
+	// You will have to modify this function.
+	ck.seq++
+	args := PutAppendArgs{Key: key, Value: value, Op: op, ClientId: ck.clientId, Seq: ck.seq}
+	t0 := time.Now()
+	var last Err = ErrTimeout
+	for timeout == 0 || time.Since(t0) < timeout {
+		var reply PutAppendReply
+		ok := ck.servers[ck.leader].Call("KVServer.PutAppend", &args, &reply)
+		if ok && reply.Err == ErrOverloaded {
+			//leader拒绝了命令，等已有的日志提交后再向它重试
+			last = ErrOverloaded
+			time.Sleep(overloadBackoff)
+			continue
+		}
+		if ok && !reply.WrongLeader && reply.Err != ErrTimeout {
+			return reply.Err
+		}
+		last = ErrTimeout
+		leaderId := raft.NoLeader
+		if ok {
+			leaderId = reply.LeaderId
+		}
+		ck.nextServer(leaderId)
+		if !ok || leaderId == raft.NoLeader {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return last
+}
+
+func (ck *Clerk) Put(key string, value string) {
+	ck.PutAppend(key, value, OpPut)
+}
+func (ck *Clerk) Append(key string, value string) {
+	ck.PutAppend(key, value, OpAppend)
+}
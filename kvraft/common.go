@@ -0,0 +1,44 @@
+package kvraft
+
+const (
+	OK             = "OK"
+	ErrNoKey       = "ErrNoKey"
+	ErrWrongLeader = "ErrWrongLeader"
+	ErrTimeout     = "ErrTimeout"
+	ErrOverloaded  = "ErrOverloaded" // leader上未提交的日志已达上限，稍后重试
+	ErrTooLarge    = "ErrTooLarge"   // 命令超过leader允许的大小，重试也不会被接受
+)
+
+type Err string
+
+// Put or Append
+type PutAppendArgs struct {
+	Key   string
+	Value string
+	Op    string // "Put" or "Append"
+	// You'll have to add definitions here.
+	// Field names must start with capital letters,
+	// otherwise RPC will break.
+	ClientId int64 // client会话id，用于raft层去重
+	Seq      int64 // client为该请求分配的序号
+}
+
+type PutAppendReply struct {
+	WrongLeader bool
+	Err         Err
+	LeaderId    int // 不是leader时，返回该节点知道的leader，便于client重定向
+}
+
+type GetArgs struct {
+	Key string
+	// You'll have to add definitions here.
+	ClientId int64
+	Seq      int64
+}
+
+type GetReply struct {
+	WrongLeader bool
+	Err         Err
+	Value       string
+	LeaderId    int
+}
@@ -0,0 +1,199 @@
+package kvraft
+
+//
+// support for k/v server tester, and for the displayer's k/v cluster.
+// servers are never restarted, only disconnected and reconnected.
+//
+
+import (
+	"hadoop-raft/labrpc"
+	"hadoop-raft/raft"
+	"runtime"
+	"sync"
+	"testing"
+
+	crand "crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+func randstring(n int) string {
+	b := make([]byte, 2*n)
+	crand.Read(b)
+	s := base64.URLEncoding.EncodeToString(b)
+	return s[0:n]
+}
+
+type config struct {
+	mu        sync.Mutex
+	t         *testing.T
+	net       *labrpc.Network
+	n         int
+	kvservers []*KVServer
+	endnames  [][]string // names of each server's sending ClientEnds
+	connected []bool     // whether each server is on the net
+	clerks    map[*Clerk][]string
+}
+
+var ncpu_once sync.Once
+
+func make_config(t *testing.T, n int, unreliable bool) *config {
+	ncpu_once.Do(func() {
+		if runtime.NumCPU() < 2 {
+			fmt.Printf("warning: only one CPU, which may conceal locking bugs\n")
+		}
+	})
+	runtime.GOMAXPROCS(4)
+	cfg := &config{}
+	cfg.t = t
+	cfg.net = labrpc.MakeNetwork()
+	cfg.n = n
+	cfg.kvservers = make([]*KVServer, cfg.n)
+	cfg.endnames = make([][]string, cfg.n)
+	cfg.connected = make([]bool, cfg.n)
+	cfg.clerks = make(map[*Clerk][]string)
+
+	// create a full set of KV servers.
+	for i := 0; i < cfg.n; i++ {
+		cfg.startServer(i)
+	}
+
+	// connect everyone
+	for i := 0; i < cfg.n; i++ {
+		cfg.connect(i)
+	}
+
+	cfg.net.Reliable(!unreliable)
+
+	return cfg
+}
+
+func (cfg *config) cleanup() {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	for i := 0; i < len(cfg.kvservers); i++ {
+		if cfg.kvservers[i] != nil {
+			cfg.kvservers[i].Kill()
+		}
+	}
+}
+
+// attach server i to servers listed in to.
+func (cfg *config) connectUnlocked(i int, to []int) {
+	// outgoing socket files
+	for j := 0; j < len(to); j++ {
+		endname := cfg.endnames[i][to[j]]
+		cfg.net.Enable(endname, true)
+	}
+
+	// incoming socket files
+	for j := 0; j < len(to); j++ {
+		endname := cfg.endnames[to[j]][i]
+		cfg.net.Enable(endname, true)
+	}
+}
+
+// attach server i to the servers that are currently on the net.
+func (cfg *config) connect(i int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.connected[i] = true
+	to := []int{}
+	for j := 0; j < cfg.n; j++ {
+		if cfg.connected[j] {
+			to = append(to, j)
+		}
+	}
+	cfg.connectUnlocked(i, to)
+
+	// clients can reach the server again.
+	for _, endnames := range cfg.clerks {
+		cfg.net.Enable(endnames[i], true)
+	}
+}
+
+// detach server i from all other servers, and from clients.
+func (cfg *config) disconnect(i int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.connected[i] = false
+
+	for j := 0; j < cfg.n; j++ {
+		if cfg.endnames[i] != nil {
+			cfg.net.Enable(cfg.endnames[i][j], false)
+		}
+		if cfg.endnames[j] != nil {
+			cfg.net.Enable(cfg.endnames[j][i], false)
+		}
+	}
+
+	for _, endnames := range cfg.clerks {
+		cfg.net.Enable(endnames[i], false)
+	}
+}
+
+// Create a clerk with clerk specific server names.
+// Give it connections to all of the servers that are on the net.
+func (cfg *config) makeClient() *Clerk {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	// a fresh set of ClientEnds.
+	ends := make([]*labrpc.ClientEnd, cfg.n)
+	endnames := make([]string, cfg.n)
+	for j := 0; j < cfg.n; j++ {
+		endnames[j] = randstring(20)
+		ends[j] = cfg.net.MakeEnd(endnames[j])
+		cfg.net.Connect(endnames[j], j)
+		cfg.net.Enable(endnames[j], cfg.connected[j])
+	}
+
+	ck := MakeClerk(ends)
+	cfg.clerks[ck] = endnames
+	return ck
+}
+
+func (cfg *config) deleteClient(ck *Clerk) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	for _, endname := range cfg.clerks[ck] {
+		cfg.net.Enable(endname, false)
+	}
+	delete(cfg.clerks, ck)
+}
+
+func (cfg *config) startServer(i int) {
+	cfg.endnames[i] = make([]string, cfg.n)
+	ends := make([]*labrpc.ClientEnd, cfg.n)
+	for j := 0; j < cfg.n; j++ {
+		cfg.endnames[i][j] = randstring(20)
+		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
+		cfg.net.Connect(cfg.endnames[i][j], j)
+	}
+
+	kv := StartKVServer(ends, i, raft.MakePersister())
+	cfg.kvservers[i] = kv
+
+	srv := labrpc.MakeServer()
+	srv.AddService(labrpc.MakeService(kv))
+	srv.AddService(labrpc.MakeService(kv.rf))
+	cfg.net.AddServer(i, srv)
+}
+
+// which server, if any, thinks it is the leader?
+func (cfg *config) Leader() (bool, int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	for i := 0; i < cfg.n; i++ {
+		if cfg.kvservers[i] == nil {
+			continue
+		}
+		_, is_leader := cfg.kvservers[i].rf.GetState()
+		if is_leader {
+			return true, i
+		}
+	}
+	return false, 0
+}
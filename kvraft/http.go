@@ -0,0 +1,137 @@
+package kvraft
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// http接口请求的最长等待时间，超时后返回ErrTimeout
+const httpTimeout = 3 * time.Second
+
+var (
+	serverMu  sync.Mutex
+	serverCfg *config
+	clerk     *Clerk
+)
+
+// StartNodes 初始化kv集群
+func StartNodes(c *gin.Context) {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	if serverCfg != nil {
+		c.JSON(200, gin.H{
+			"msg": "already started",
+		})
+		return
+	}
+	s := c.Query("servers")
+	servers, _ := strconv.ParseInt(s, 10, 64)
+	serverCfg = make_config(nil, int(servers), false)
+	clerk = serverCfg.makeClient()
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// CleanNodes 删除kv集群的所有节点
+func CleanNodes(c *gin.Context) {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	if serverCfg != nil {
+		serverCfg.cleanup()
+	}
+	serverCfg = nil
+	clerk = nil
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// DisconnectNode 断开编号为number的kv节点
+func DisconnectNode(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	serverCfg.disconnect(number)
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// ReconnectNode 重连编号为number的kv节点
+func ReconnectNode(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	serverCfg.connect(number)
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// GetState 获取编号为number的kv节点的raft状态以及已apply的kv数据
+func GetState(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	serverCfg.mu.Lock()
+	kv := serverCfg.kvservers[number]
+	connected := serverCfg.connected[number]
+	serverCfg.mu.Unlock()
+
+	data, applied := kv.Data()
+	c.JSON(200, gin.H{
+		"raft":      kv.Raft().Status(),
+		"connected": connected,
+		"data":      data,
+		"applied":   applied,
+	})
+}
+
+// Put 通过client写入key的值，client会自动重定向到leader
+func Put(c *gin.Context) {
+	putAppend(c, OpPut)
+}
+
+// Append 通过client在key的值后追加内容
+func Append(c *gin.Context) {
+	putAppend(c, OpAppend)
+}
+
+func putAppend(c *gin.Context, op string) {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	err := clerk.PutAppendWithin(c.Query("key"), c.Query("value"), op, httpTimeout)
+	c.JSON(200, gin.H{
+		"err":    err,
+		"leader": clerk.leader,
+	})
+}
+
+// Get 通过client读取key的值
+func Get(c *gin.Context) {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	value, err := clerk.GetWithin(c.Query("key"), httpTimeout)
+	c.JSON(200, gin.H{
+		"value":  value,
+		"err":    err,
+		"leader": clerk.leader,
+	})
+}
+
+// Routes 注册kv服务的http接口
+func Routes(r *gin.Engine) {
+	r.GET("/api/kv/startnodes", StartNodes)
+	r.GET("/api/kv/cleannodes", CleanNodes)
+	r.GET("/api/kv/disconnect", DisconnectNode)
+	r.GET("/api/kv/reconnect", ReconnectNode)
+	r.GET("/api/kv/getstate", GetState)
+	r.GET("/api/kv/put", Put)
+	r.GET("/api/kv/append", Append)
+	r.GET("/api/kv/get", Get)
+}
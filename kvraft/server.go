@@ -0,0 +1,223 @@
+package kvraft
+
+import (
+	"hadoop-raft/labrpc"
+	"hadoop-raft/raft"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const Debug = 0
+
+func DPrintf(format string, a ...interface{}) (n int, err error) {
+	if Debug > 0 {
+		log.Printf(format, a...)
+	}
+	return
+}
+
+const (
+	OpGet    = "Get"
+	OpPut    = "Put"
+	OpAppend = "Append"
+)
+
+// 等待一条命令被apply的最长时间，超时后client应重试
+const applyTimeout = 500 * time.Millisecond
+
+type Op struct {
+	// Your definitions here.
+	// Field names must start with capital letters,
+	// otherwise RPC will break.
+	Type     string
+	Key      string
+	Value    string
+	ClientId int64
+	Seq      int64
+}
+
+// 某个index上被apply的命令，交给等待该index的RPC handler
+type applyResult struct {
+	op    Op
+	value string
+	err   Err
+}
+
+type KVServer struct {
+	mu      sync.Mutex
+	me      int
+	rf      *raft.Raft
+	applyCh chan raft.ApplyMsg
+	dead    int32
+
+	// Your definitions here.
+	data        map[string]string        // 已apply的kv数据
+	lastApplied int                      // 最近一次apply的日志index
+	waiters     map[int]chan applyResult // 等待某个index被apply的handler
+}
+
+func (kv *KVServer) Get(args *GetArgs, reply *GetReply) {
+	// Your code here.
+	op := Op{Type: OpGet, Key: args.Key, ClientId: args.ClientId, Seq: args.Seq}
+	res, wrongLeader, leaderId := kv.submit(op)
+	reply.WrongLeader = wrongLeader
+	reply.LeaderId = leaderId
+	reply.Err = res.err
+	reply.Value = res.value
+}
+
+func (kv *KVServer) PutAppend(args *PutAppendArgs, reply *PutAppendReply) {
+	// Your code here.
+	op := Op{Type: args.Op, Key: args.Key, Value: args.Value, ClientId: args.ClientId, Seq: args.Seq}
+	res, wrongLeader, leaderId := kv.submit(op)
+	reply.WrongLeader = wrongLeader
+	reply.LeaderId = leaderId
+	reply.Err = res.err
+}
+
+// submit 把命令交给raft，并等待它在同一个index上被apply
+func (kv *KVServer) submit(op Op) (applyResult, bool, int) {
+	var p raft.Proposal
+	if op.Type == OpGet {
+		//读请求不需要去重，重复执行也只是读到更新的值
+		p = kv.rf.Propose(raft.NoClient, 0, op)
+	} else {
+		p = kv.rf.Propose(op.ClientId, op.Seq, op)
+	}
+	//leader超过提交限制时明确拒绝，命令没有进入日志
+	switch p.Err {
+	case "":
+	case raft.ErrOverloaded:
+		return applyResult{err: ErrOverloaded}, false, kv.rf.GetLeader()
+	case raft.ErrTooLarge:
+		return applyResult{err: ErrTooLarge}, false, kv.rf.GetLeader()
+	default:
+		return applyResult{err: ErrWrongLeader}, true, kv.rf.GetLeader()
+	}
+	index := p.Index
+
+	kv.mu.Lock()
+	ch := make(chan applyResult, 1)
+	kv.waiters[index] = ch
+	kv.mu.Unlock()
+
+	defer func() {
+		kv.mu.Lock()
+		if kv.waiters[index] == ch {
+			delete(kv.waiters, index)
+		}
+		kv.mu.Unlock()
+	}()
+
+	select {
+	case res := <-ch:
+		//该index上apply的不是我们提交的命令，说明leader已经更换
+		if res.op.ClientId != op.ClientId || res.op.Seq != op.Seq {
+			return applyResult{err: ErrWrongLeader}, true, kv.rf.GetLeader()
+		}
+		return res, false, kv.rf.GetLeader()
+	case <-time.After(applyTimeout):
+		return applyResult{err: ErrTimeout}, false, kv.rf.GetLeader()
+	}
+}
+
+// applier 按顺序把raft提交的命令应用到kv数据上
+func (kv *KVServer) applier() {
+	for m := range kv.applyCh {
+		if kv.killed() {
+			continue
+		}
+		op, ok := m.Command.(Op)
+		if !ok {
+			continue
+		}
+
+		kv.mu.Lock()
+		res := applyResult{op: op, err: OK}
+		switch {
+		case op.Type == OpGet:
+			value, exist := kv.data[op.Key]
+			if !exist {
+				res.err = ErrNoKey
+			}
+			res.value = value
+		case m.Duplicate:
+			//client重试的请求已经生效过，不再修改数据
+		case op.Type == OpPut:
+			kv.data[op.Key] = op.Value
+		case op.Type == OpAppend:
+			kv.data[op.Key] += op.Value
+		}
+		kv.lastApplied = m.Index
+
+		if ch, ok := kv.waiters[m.Index]; ok {
+			ch <- res
+			delete(kv.waiters, m.Index)
+		}
+		kv.mu.Unlock()
+	}
+}
+
+// Data 返回该节点已apply的kv数据的拷贝，以及对应的日志index
+func (kv *KVServer) Data() (map[string]string, int) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	data := make(map[string]string, len(kv.data))
+	for k, v := range kv.data {
+		data[k] = v
+	}
+	return data, kv.lastApplied
+}
+
+// Raft 返回底层的raft节点
+func (kv *KVServer) Raft() *raft.Raft {
+	return kv.rf
+}
+
+//
+// the tester calls Kill() when a KVServer instance won't
+// be needed again. you are not required to do anything
+// in Kill(), but it might be convenient to (for example)
+// turn off debug output from this instance.
+//
+func (kv *KVServer) Kill() {
+	atomic.StoreInt32(&kv.dead, 1)
+	kv.rf.Kill()
+	// Your code here, if desired.
+}
+
+func (kv *KVServer) killed() bool {
+	return atomic.LoadInt32(&kv.dead) == 1
+}
+
+//
+// servers[] contains the ports of the set of
+// servers that will cooperate via Raft to
+// form the fault-tolerant key/value service.
+// me is the index of the current server in servers[].
+// the k/v server should store snapshots with persister.SaveSnapshot(),
+// and Raft should save its state (including log) with persister.SaveRaftState().
+// StartKVServer() must return quickly, so it should start goroutines
+// for any long-running work.
+//
+func StartKVServer(servers []*labrpc.ClientEnd, me int, persister *raft.Persister) *KVServer {
+	// call labrpc.Register on structures you want
+	// Go's RPC library to marshall/unmarshall.
+	labrpc.Register(Op{})
+
+	kv := new(KVServer)
+	kv.me = me
+
+	// You may need initialization code here.
+	kv.data = map[string]string{}
+	kv.waiters = map[int]chan applyResult{}
+
+	kv.applyCh = make(chan raft.ApplyMsg)
+	kv.rf = raft.Make(servers, me, persister, kv.applyCh)
+
+	go kv.applier()
+
+	return kv
+}
@@ -0,0 +1,200 @@
+package kvraft
+
+import "testing"
+import "strconv"
+import "time"
+import "fmt"
+import "sync"
+import "hadoop-raft/raft"
+
+// The tester generously allows solutions to complete elections in one second
+// (much more than the paper's range of timeouts).
+const electionTimeout = 1 * time.Second
+
+func check(t *testing.T, ck *Clerk, key string, value string) {
+	v := ck.Get(key)
+	if v != value {
+		t.Fatalf("Get(%v): expected:\n%v\nreceived:\n%v", key, value, v)
+	}
+}
+
+// every connected server must eventually apply the same k/v data.
+func checkReplicas(t *testing.T, cfg *config, key string, value string) {
+	for iters := 0; iters < 50; iters++ {
+		agree := true
+		for i := 0; i < cfg.n; i++ {
+			if !cfg.connected[i] {
+				continue
+			}
+			data, _ := cfg.kvservers[i].Data()
+			if data[key] != value {
+				agree = false
+			}
+		}
+		if agree {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("servers did not all apply %v=%v", key, value)
+}
+
+func TestBasic3A(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false)
+	defer cfg.cleanup()
+
+	ck := cfg.makeClient()
+
+	fmt.Printf("Test: one client put/append/get ...\n")
+
+	ck.Put("a", "x")
+	ck.Append("a", "y")
+	check(t, ck, "a", "xy")
+	check(t, ck, "missing", "")
+	checkReplicas(t, cfg, "a", "xy")
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestLeaderRedirect3A(t *testing.T) {
+	const nservers = 5
+	cfg := make_config(t, nservers, false)
+	defer cfg.cleanup()
+
+	ck := cfg.makeClient()
+
+	fmt.Printf("Test: client follows leader across elections ...\n")
+
+	ck.Put("k", "0")
+	for i := 1; i <= 3; i++ {
+		_, leader := cfg.Leader()
+		cfg.disconnect(leader)
+
+		ck.Append("k", strconv.Itoa(i))
+
+		cfg.connect(leader)
+	}
+	check(t, ck, "k", "0123")
+	checkReplicas(t, cfg, "k", "0123")
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestUnreliableAppend3A(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, true)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test: concurrent appends apply once over unreliable net ...\n")
+
+	// clerks retry lost requests; sessions must keep every
+	// append from taking effect more than once.
+	const nclients = 3
+	const nappends = 10
+	var wg sync.WaitGroup
+	for c := 0; c < nclients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			ck := cfg.makeClient()
+			defer cfg.deleteClient(ck)
+			key := strconv.Itoa(c)
+			for i := 0; i < nappends; i++ {
+				ck.Append(key, "x")
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	cfg.net.Reliable(true)
+	ck := cfg.makeClient()
+	expected := ""
+	for i := 0; i < nappends; i++ {
+		expected += "x"
+	}
+	for c := 0; c < nclients; c++ {
+		check(t, ck, strconv.Itoa(c), expected)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestTimeout3A(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false)
+	defer cfg.cleanup()
+
+	ck := cfg.makeClient()
+
+	fmt.Printf("Test: no progress without a majority ...\n")
+
+	ck.Put("a", "1")
+	for i := 0; i < nservers; i++ {
+		cfg.disconnect(i)
+	}
+	cfg.connect(0)
+
+	if err := ck.PutAppendWithin("a", "2", OpPut, electionTimeout); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout without a majority, got %v", err)
+	}
+
+	// the minority leader may have logged the Put, and may
+	// still get it committed once the partition heals.
+	for i := 0; i < nservers; i++ {
+		cfg.connect(i)
+	}
+	if v := ck.Get("a"); v != "1" && v != "2" {
+		t.Fatalf("Get(a): expected 1 or 2, received %v", v)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestOverloaded3A(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false)
+	defer cfg.cleanup()
+
+	ck := cfg.makeClient()
+
+	fmt.Printf("Test: a leader over its limits rejects requests ...\n")
+
+	ck.Put("a", "1")
+	_, leader := cfg.Leader()
+	cfg.kvservers[leader].rf.SetLimits(raft.Limits{MaxEntryBytes: 1000, MaxUncommittedEntries: 1})
+
+	// a command the leader will never accept fails right away.
+	big := ""
+	for len(big) < 2000 {
+		big += "x"
+	}
+	if err := ck.PutAppendWithin("a", big, OpPut, 5*electionTimeout); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+
+	// without its followers the leader cannot commit, so its single
+	// uncommitted entry fills the log and later requests are refused.
+	for i := 0; i < nservers; i++ {
+		if i != leader {
+			cfg.disconnect(i)
+		}
+	}
+	// a single attempt, which the leader logs but cannot commit.
+	if err := ck.PutAppendWithin("a", "2", OpPut, applyTimeout/2); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout without a majority, got %v", err)
+	}
+	ck2 := cfg.makeClient()
+	if err := ck2.PutAppendWithin("b", "1", OpPut, electionTimeout); err != ErrOverloaded {
+		t.Fatalf("expected ErrOverloaded, got %v", err)
+	}
+
+	// once the backlog commits, requests are accepted again.
+	for i := 0; i < nservers; i++ {
+		cfg.connect(i)
+	}
+	ck2.Put("b", "2")
+	check(t, ck2, "b", "2")
+
+	fmt.Printf("  ... Passed\n")
+}
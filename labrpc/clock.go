@@ -0,0 +1,220 @@
+package labrpc
+
+//
+// the source of time and randomness for the network and for Raft.
+//
+// RealClock uses the wall clock and math/rand, as before.
+// a SimClock stands still until Advance() is called, and draws
+// its random numbers from a seeded source, so the timeouts and
+// delays of a run are fixed by its seed. goroutines still run
+// concurrently between calls to Advance(); give them a moment
+// (or wait for quiescence) before advancing again.
+//
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	Intn(n int) int
+}
+
+type RealClock struct{}
+
+func MakeRealClock() RealClock {
+	return RealClock{}
+}
+
+func (RealClock) Now() time.Time                         { return time.Now() }
+func (RealClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (RealClock) Intn(n int) int                         { return rand.Intn(n) }
+
+// a timer waiting for simulated time to reach when.
+type simTimer struct {
+	when time.Time
+	seq  int64 // timers due at the same instant fire in creation order
+	ch   chan time.Time
+}
+
+type timerHeap []*simTimer
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+func (h timerHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *timerHeap) Push(x interface{}) { *h = append(*h, x.(*simTimer)) }
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
+
+type SimClock struct {
+	mu     sync.Mutex
+	seed   int64
+	now    time.Time
+	seq    int64
+	timers timerHeap
+	rand   *rand.Rand
+}
+
+// simulated time starts at the same instant for every seed,
+// so that logged timestamps are comparable between runs.
+var simEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func MakeSimClock(seed int64) *SimClock {
+	c := &SimClock{}
+	c.seed = seed
+	c.now = simEpoch
+	c.rand = rand.New(rand.NewSource(seed))
+	return c
+}
+
+func (c *SimClock) Seed() int64 {
+	return c.seed
+}
+
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// the returned channel receives once Advance() has moved the
+// clock at least d past the current simulated time.
+func (c *SimClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.seq++
+	heap.Push(&c.timers, &simTimer{c.now.Add(d), c.seq, ch})
+	return ch
+}
+
+func (c *SimClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *SimClock) Intn(n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Intn(n)
+}
+
+// number of timers that have not fired yet.
+func (c *SimClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// move simulated time forward by d, firing every timer that
+// comes due, in order.
+func (c *SimClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].when.After(end) {
+		t := heap.Pop(&c.timers).(*simTimer)
+		c.now = t.when
+		t.ch <- t.when
+	}
+	c.now = end
+}
+
+// move simulated time to the next timer and fire it, along with
+// any others due at the same instant. returns false if no timer
+// is pending.
+func (c *SimClock) Step() bool {
+	c.mu.Lock()
+	if len(c.timers) == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	d := c.timers[0].when.Sub(c.now)
+	c.mu.Unlock()
+	c.Advance(d)
+	return true
+}
+
+// a SkewedClock runs at rate times the speed of the clock it
+// wraps: with rate 2, Sleep(d) returns after d/2 of base time and
+// Now() moves twice as fast. used to give each server its own
+// drifting clock. the rate may be changed at any time; timers
+// already started keep the rate they were started with.
+type SkewedClock struct {
+	mu         sync.Mutex
+	base       Clock
+	rate       float64
+	anchorBase time.Time // base time at the last rate change
+	anchorNow  time.Time // skewed time at the last rate change
+}
+
+func MakeSkewedClock(base Clock, rate float64) *SkewedClock {
+	c := &SkewedClock{}
+	c.base = base
+	c.rate = rate
+	c.anchorBase = base.Now()
+	c.anchorNow = c.anchorBase
+	return c
+}
+
+func (c *SkewedClock) nowLocked() time.Time {
+	elapsed := c.base.Now().Sub(c.anchorBase)
+	return c.anchorNow.Add(time.Duration(float64(elapsed) * c.rate))
+}
+
+func (c *SkewedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nowLocked()
+}
+
+func (c *SkewedClock) Rate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rate
+}
+
+func (c *SkewedClock) SetRate(rate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.anchorNow = c.nowLocked()
+	c.anchorBase = c.base.Now()
+	c.rate = rate
+}
+
+// how long d of skewed time takes on the base clock.
+func (c *SkewedClock) toBase(d time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Duration(float64(d) / c.rate)
+}
+
+func (c *SkewedClock) After(d time.Duration) <-chan time.Time {
+	return c.base.After(c.toBase(d))
+}
+
+func (c *SkewedClock) Sleep(d time.Duration) {
+	c.base.Sleep(c.toBase(d))
+}
+
+func (c *SkewedClock) Intn(n int) int {
+	return c.base.Intn(n)
+}
@@ -0,0 +1,48 @@
+package labrpc
+
+//
+// registry of concrete types that travel inside interface{}
+// values, e.g. Raft log commands. gob can only encode or decode
+// such a value if its type was registered first, so services
+// register their command types here before using them.
+//
+// labrpc.Register(Op{}) -- make Op encodable inside an interface{}.
+// labrpc.Registered() -- names of all registered types.
+//
+
+import "encoding/gob"
+import "reflect"
+import "sort"
+import "sync"
+
+var codecMu sync.Mutex
+var codecs = map[string]reflect.Type{}
+
+// make values of value's concrete type encodable when they
+// are stored in an interface{} field of RPC args or replies.
+// registering the same type again is a no-op.
+func Register(value interface{}) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+
+	t := reflect.TypeOf(value)
+	name := t.String()
+	if _, ok := codecs[name]; ok {
+		return
+	}
+	gob.Register(value)
+	codecs[name] = t
+}
+
+// names of all registered types, sorted.
+func Registered() []string {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+
+	names := []string{}
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
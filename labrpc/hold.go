@@ -0,0 +1,218 @@
+package labrpc
+
+//
+// manual stepping: in manual mode the network does not deliver
+// RPCs by itself. every request, and later its reply, is held
+// until the caller decides what happens to it:
+//
+// net.Manual(true) -- hold messages from now on.
+// net.Held() -- list the held messages, oldest first.
+// net.Deliver(id) -- let a message through.
+// net.Drop(id) -- lose it; the Call() returns false.
+// net.Duplicate(id) -- hold a copy of a request. the copy is
+//   executed by the server when delivered, but its reply is
+//   discarded, as if the original had been retransmitted.
+// net.Delay(id, d) -- deliver it after d on the network's clock.
+// net.Manual(false) -- stop holding, and deliver everything held.
+//
+// messages on disabled ends are not held; they fail as usual.
+//
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	HeldRequest = "request"
+	HeldReply   = "reply"
+)
+
+type HeldMessage struct {
+	Id         int         `json:"id"`
+	Kind       string      `json:"kind"` // HeldRequest or HeldReply
+	EndName    interface{} `json:"endName"`
+	ServerName interface{} `json:"serverName"`
+	SvcMeth    string      `json:"svcMeth"`
+	Body       string      `json:"body"`      // the decoded args or reply
+	Duplicate  bool        `json:"duplicate"` // a copy made by Duplicate()
+}
+
+const (
+	opDeliver = iota
+	opDrop
+	opDelay
+)
+
+type heldAction struct {
+	op    int
+	delay time.Duration
+}
+
+type heldMsg struct {
+	HeldMessage
+	req    reqMsg
+	server *Server
+	action chan heldAction
+}
+
+func (rn *Network) Manual(yes bool) {
+	rn.mu.Lock()
+	rn.manual = yes
+	var release []*heldMsg
+	if !yes {
+		for id, m := range rn.held {
+			release = append(release, m)
+			delete(rn.held, id)
+		}
+	}
+	rn.mu.Unlock()
+
+	for _, m := range release {
+		m.action <- heldAction{op: opDeliver}
+	}
+}
+
+func (rn *Network) IsManual() bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return rn.manual
+}
+
+func (rn *Network) Held() []HeldMessage {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	msgs := make([]HeldMessage, 0, len(rn.held))
+	for _, m := range rn.held {
+		msgs = append(msgs, m.HeldMessage)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Id < msgs[j].Id })
+	return msgs
+}
+
+func (rn *Network) Deliver(id int) bool {
+	return rn.release(id, heldAction{op: opDeliver})
+}
+
+func (rn *Network) Drop(id int) bool {
+	return rn.release(id, heldAction{op: opDrop})
+}
+
+func (rn *Network) Delay(id int, d time.Duration) bool {
+	return rn.release(id, heldAction{op: opDelay, delay: d})
+}
+
+// hold a copy of request id; returns the copy's id.
+func (rn *Network) Duplicate(id int) (int, bool) {
+	rn.mu.Lock()
+	m, ok := rn.held[id]
+	if !ok || m.Kind != HeldRequest {
+		rn.mu.Unlock()
+		return -1, false
+	}
+	req := m.req
+	// nobody waits for the copy's reply.
+	req.replyCh = make(chan replyMsg, 1)
+	dup := rn.holdLocked(HeldRequest, req, m.ServerName, m.server, nil, true)
+	rn.mu.Unlock()
+
+	go rn.processHeld(dup, req, m.ServerName, m.server)
+	return dup.Id, true
+}
+
+func (rn *Network) release(id int, a heldAction) bool {
+	rn.mu.Lock()
+	m, ok := rn.held[id]
+	delete(rn.held, id)
+	rn.mu.Unlock()
+	if ok {
+		m.action <- a
+	}
+	return ok
+}
+
+// add a message to the hold queue. the caller holds rn.mu.
+func (rn *Network) holdLocked(kind string, req reqMsg, servername interface{}, server *Server, reply []byte, dup bool) *heldMsg {
+	rn.nextHeld++
+	m := &heldMsg{}
+	m.Id = rn.nextHeld
+	m.Kind = kind
+	m.EndName = req.endname
+	m.ServerName = servername
+	m.SvcMeth = req.svcMeth
+	m.Duplicate = dup
+	if kind == HeldRequest {
+		m.Body = describe(req.argsType, req.args)
+	} else {
+		m.Body = describe(req.replyType, reply)
+	}
+	m.req = req
+	m.server = server
+	m.action = make(chan heldAction, 1)
+	rn.held[m.Id] = m
+	return m
+}
+
+// wait until the user acts on held message m. returns false if
+// the message was dropped. messages released by Manual(false)
+// are delivered.
+func (rn *Network) await(m *heldMsg) bool {
+	a := <-m.action
+	switch a.op {
+	case opDrop:
+		return false
+	case opDelay:
+		rn.Clock().Sleep(a.delay)
+	}
+	return true
+}
+
+// hold a request that has just been sent, then its reply.
+func (rn *Network) processHeldReq(req reqMsg, servername interface{}, server *Server) {
+	rn.mu.Lock()
+	m := rn.holdLocked(HeldRequest, req, servername, server, nil, false)
+	rn.mu.Unlock()
+	rn.processHeld(m, req, servername, server)
+}
+
+func (rn *Network) processHeld(m *heldMsg, req reqMsg, servername interface{}, server *Server) {
+	if !rn.await(m) || rn.IsServerDead(req.endname, servername, server) {
+		req.replyCh <- replyMsg{false, nil}
+		return
+	}
+
+	reply := server.dispatch(req)
+
+	rn.mu.Lock()
+	held := rn.manual
+	if held {
+		m = rn.holdLocked(HeldReply, req, servername, server, reply.reply, false)
+	}
+	rn.mu.Unlock()
+
+	if held && !rn.await(m) {
+		req.replyCh <- replyMsg{false, nil}
+		return
+	}
+	if rn.IsServerDead(req.endname, servername, server) || rn.replyCut(req.endname, servername) {
+		req.replyCh <- replyMsg{false, nil}
+		return
+	}
+	req.replyCh <- reply
+}
+
+// decode gob-encoded data of type t (usually a pointer) for display.
+func describe(t reflect.Type, data []byte) string {
+	if t == nil {
+		return ""
+	}
+	v := reflect.New(t)
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(v.Interface()); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return fmt.Sprintf("%+v", reflect.Indirect(reflect.Indirect(v)).Interface())
+}
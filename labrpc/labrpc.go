@@ -16,12 +16,18 @@ package labrpc
 // don't include references to program objects.
 //
 // net := MakeNetwork() -- holds network, clients, servers.
+//   MakeNetwork(WithSeed(seed)) fixes the network's random choices;
+//   the seed is logged, and $LABRPC_SEED replays it.
 // end := net.MakeEnd(endname) -- create a client end-point, to talk to one server.
 // net.AddServer(servername, server) -- adds a named server to network.
 // net.DeleteServer(servername) -- eliminate the named server.
 // net.Connect(endname, servername) -- connect a client to a server.
 // net.Enable(endname, enabled) -- enable/disable a client.
 // net.Reliable(bool) -- false means drop/delay messages
+// net.Partition(groups) -- split servers into groups; see partition.go.
+// net.SetLink(from, to, conditions) -- delay, lose or duplicate
+//   messages between two servers; see link.go.
+// net.EnableLink(from, to, enabled) -- cut one direction only.
 //
 // end.Call("Raft.AppendEntries", &args, &reply) -- send an RPC, wait for reply.
 // the "Raft" is the name of the server struct to be called.
@@ -56,15 +62,18 @@ import "reflect"
 import "sync"
 import "log"
 import "strings"
-import "math/rand"
 import "time"
+import "math/rand"
+import "os"
+import "strconv"
 
 type reqMsg struct {
-	endname  interface{} // name of sending ClientEnd
-	svcMeth  string      // e.g. "Raft.AppendEntries"
-	argsType reflect.Type
-	args     []byte
-	replyCh  chan replyMsg
+	endname   interface{} // name of sending ClientEnd
+	svcMeth   string      // e.g. "Raft.AppendEntries"
+	argsType  reflect.Type
+	args      []byte
+	replyType reflect.Type // only used to display held replies
+	replyCh   chan replyMsg
 }
 
 type replyMsg struct {
@@ -85,6 +94,7 @@ func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bo
 	req.endname = e.endname
 	req.svcMeth = svcMeth
 	req.argsType = reflect.TypeOf(args)
+	req.replyType = reflect.TypeOf(reply)
 	req.replyCh = make(chan replyMsg)
 
 	qb := new(bytes.Buffer)
@@ -116,17 +126,64 @@ type Network struct {
 	enabled        map[interface{}]bool        // by end name
 	servers        map[interface{}]*Server     // servers, by name
 	connections    map[interface{}]interface{} // endname -> servername
+	owners         map[interface{}]interface{} // endname -> servername that sends on it
+	group          map[interface{}]string      // servername -> partition, nil when healed
+	partitions     map[string][]interface{}    // partition -> servernames
+	links          map[linkKey]LinkConfig      // (from, to) servernames -> conditions
+	cut            map[linkKey]bool            // one-way links disabled by EnableLink()
 	endCh          chan reqMsg
+	clock          Clock      // source of delays
+	seed           int64      // of rand
+	rand           *rand.Rand // source of drops, delays and reordering
+	manual         bool       // hold messages until told what to do with them
+	held           map[int]*heldMsg
+	nextHeld       int
+}
+
+type NetworkOption func(rn *Network)
+
+// draw the network's random choices (drops, delays, reordering)
+// from a source seeded with seed. this fixes the sequence of
+// choices, not the interleaving: the choices are made in the
+// order RPCs reach the network, and goroutine scheduling can
+// still change that order and so which RPC gets which choice,
+// even with a SimClock. a seed makes replaying a failed run on an
+// unreliable network more likely, not certain.
+func WithSeed(seed int64) NetworkOption {
+	return func(rn *Network) {
+		rn.seed = seed
+	}
 }
 
-func MakeNetwork() *Network {
+// the seed of a network made without WithSeed(): $LABRPC_SEED if
+// set, so that a logged seed can be replayed without changing any
+// code, otherwise the time.
+func defaultSeed() int64 {
+	if seed, err := strconv.ParseInt(os.Getenv("LABRPC_SEED"), 10, 64); err == nil {
+		return seed
+	}
+	return time.Now().UnixNano()
+}
+
+func MakeNetwork(opts ...NetworkOption) *Network {
 	rn := &Network{}
+	rn.seed = defaultSeed()
+	for _, opt := range opts {
+		opt(rn)
+	}
+	rn.rand = rand.New(rand.NewSource(rn.seed))
+	log.Printf("labrpc: network seed %d\n", rn.seed)
 	rn.reliable = true
 	rn.ends = map[interface{}]*ClientEnd{}
 	rn.enabled = map[interface{}]bool{}
 	rn.servers = map[interface{}]*Server{}
 	rn.connections = map[interface{}](interface{}){}
+	rn.owners = map[interface{}]interface{}{}
+	rn.links = map[linkKey]LinkConfig{}
+	rn.cut = map[linkKey]bool{}
 	rn.endCh = make(chan reqMsg)
+	rn.clock = MakeRealClock()
+	rn.held = map[int]*heldMsg{}
 
 	// single goroutine to handle all ClientEnd.Call()s
 	go func() {
@@ -138,6 +195,37 @@ func MakeNetwork() *Network {
 	return rn
 }
 
+// use c for all delays, e.g. a SimClock to make a run
+// reproducible. call before sending any RPCs.
+func (rn *Network) SetClock(c Clock) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.clock = c
+}
+
+func (rn *Network) Clock() Clock {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.clock
+}
+
+func (rn *Network) Seed() int64 {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.seed
+}
+
+// a random number in [0, n) from the network's seeded source.
+func (rn *Network) intn(n int) int {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.rand.Intn(n)
+}
+
 func (rn *Network) Reliable(yes bool) {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
@@ -165,8 +253,9 @@ func (rn *Network) ReadEndnameInfo(endname interface{}) (enabled bool,
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
 
-	enabled = rn.enabled[endname]
 	servername = rn.connections[endname]
+	enabled = rn.enabled[endname] && !rn.partitioned(endname, servername) &&
+		!rn.cutLocked(endname, servername, false)
 	if servername != nil {
 		server = rn.servers[servername]
 	}
@@ -179,7 +268,8 @@ func (rn *Network) IsServerDead(endname interface{}, servername interface{}, ser
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
 
-	if rn.enabled[endname] == false || rn.servers[servername] != server {
+	if rn.enabled[endname] == false || rn.partitioned(endname, servername) ||
+		rn.servers[servername] != server {
 		return true
 	}
 	return false
@@ -187,20 +277,43 @@ func (rn *Network) IsServerDead(endname interface{}, servername interface{}, ser
 
 func (rn *Network) ProcessReq(req reqMsg) {
 	enabled, servername, server, reliable, longreordering := rn.ReadEndnameInfo(req.endname)
+	clock := rn.Clock()
+
+	if enabled && servername != nil && server != nil && rn.IsManual() {
+		rn.processHeldReq(req, servername, server)
+	} else if enabled && servername != nil && server != nil {
+		reqLink, repLink := rn.readLinks(req.endname, servername)
 
-	if enabled && servername != nil && server != nil {
 		if reliable == false {
 			// short delay
-			ms := (rand.Int() % 27)
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+			ms := rn.intn(27)
+			clock.Sleep(time.Duration(ms) * time.Millisecond)
 		}
 
-		if reliable == false && (rand.Int()%1000) < 100 {
+		if reliable == false && rn.intn(1000) < 100 {
 			// drop the request, return as if timeout
 			req.replyCh <- replyMsg{false, nil}
 			return
 		}
 
+		if reqLink != nil {
+			clock.Sleep(reqLink.delay(rn))
+			if rn.chance(reqLink.Drop) {
+				req.replyCh <- replyMsg{false, nil}
+				return
+			}
+			if rn.chance(reqLink.Duplicate) {
+				// the copy makes its own way over the link,
+				// and nobody sees its reply.
+				go func() {
+					clock.Sleep(reqLink.delay(rn))
+					if !rn.IsServerDead(req.endname, servername, server) {
+						server.dispatch(req)
+					}
+				}()
+			}
+		}
+
 		// execute the request (call the RPC handler).
 		// in a separate thread so that we can periodically check
 		// if the server has been killed and the RPC should get a
@@ -221,11 +334,15 @@ func (rn *Network) ProcessReq(req reqMsg) {
 			select {
 			case reply = <-ech:
 				replyOK = true
-			case <-time.After(100 * time.Millisecond):
+			case <-clock.After(100 * time.Millisecond):
 				serverDead = rn.IsServerDead(req.endname, servername, server)
 			}
 		}
 
+		if replyOK && repLink != nil {
+			clock.Sleep(repLink.delay(rn))
+		}
+
 		// do not reply if DeleteServer() has been called, i.e.
 		// the server has been killed. this is needed to avoid
 		// situation in which a client gets a positive reply
@@ -237,13 +354,19 @@ func (rn *Network) ProcessReq(req reqMsg) {
 		if replyOK == false || serverDead == true {
 			// server was killed while we were waiting; return error.
 			req.replyCh <- replyMsg{false, nil}
-		} else if reliable == false && (rand.Int()%1000) < 100 {
+		} else if rn.replyCut(req.endname, servername) {
+			// the server executed the request, but the way
+			// back is cut.
+			req.replyCh <- replyMsg{false, nil}
+		} else if reliable == false && rn.intn(1000) < 100 {
 			// drop the reply, return as if timeout
 			req.replyCh <- replyMsg{false, nil}
-		} else if longreordering == true && rand.Intn(900) < 600 {
+		} else if repLink != nil && rn.chance(repLink.Drop) {
+			req.replyCh <- replyMsg{false, nil}
+		} else if longreordering == true && rn.intn(900) < 600 {
 			// delay the response for a while
-			ms := 200 + rand.Intn(1+rand.Intn(2000))
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+			ms := 200 + rn.intn(1+rn.intn(2000))
+			clock.Sleep(time.Duration(ms) * time.Millisecond)
 			req.replyCh <- reply
 		} else {
 			req.replyCh <- reply
@@ -254,13 +377,13 @@ func (rn *Network) ProcessReq(req reqMsg) {
 		if rn.longDelays {
 			// let Raft tests check that leader doesn't send
 			// RPCs synchronously.
-			ms = (rand.Int() % 7000)
+			ms = rn.intn(7000)
 		} else {
 			// many kv tests require the client to try each
 			// server in fairly rapid succession.
-			ms = (rand.Int() % 100)
+			ms = rn.intn(100)
 		}
-		time.Sleep(time.Duration(ms) * time.Millisecond)
+		clock.Sleep(time.Duration(ms) * time.Millisecond)
 		req.replyCh <- replyMsg{false, nil}
 	}
 
@@ -422,6 +545,14 @@ func MakeService(rcvr interface{}) *Service {
 	return svc
 }
 
+// like MakeService, but registers rcvr under name instead of
+// its type name, e.g. so that a router can receive "Raft.*" calls.
+func MakeNamedService(name string, rcvr interface{}) *Service {
+	svc := MakeService(rcvr)
+	svc.name = name
+	return svc
+}
+
 func (svc *Service) dispatch(methname string, req reqMsg) replyMsg {
 	if method, ok := svc.methods[methname]; ok {
 		// prepare space into which to read the argument.
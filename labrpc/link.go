@@ -0,0 +1,170 @@
+package labrpc
+
+//
+// per-link network conditions. a link is one direction between two
+// servers: a request on an end travels on the link from the end's
+// owner (see SetOwner) to the server it is connected to, and the
+// reply travels back on the opposite link.
+//
+// net.SetLink(from, to, LinkConfig{...}) -- set the conditions of
+//   the link from server from to server to.
+// net.ClearLink(from, to) -- back to a perfect link.
+// net.Links() -- the links that have conditions set or are disabled.
+// net.EnableLink(from, to, false) -- cut the link from server from
+//   to server to: requests from from to to are lost, and so are the
+//   replies to RPCs that to sends to from. the opposite direction
+//   keeps working, e.g. a server can send but not receive.
+//
+// link conditions apply on top of Reliable(false), only to ends
+// with an owner, and not to messages held in manual mode. a cut
+// link applies to held messages too.
+//
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// the servernames at the two ends of a link, from and to.
+type linkKey [2]interface{}
+
+type LinkConfig struct {
+	MinDelay  time.Duration `json:"minDelay"`  // each message is delayed uniformly
+	MaxDelay  time.Duration `json:"maxDelay"`  // between MinDelay and MaxDelay
+	Drop      float64       `json:"drop"`      // probability that a message is lost
+	Duplicate float64       `json:"duplicate"` // probability that a request is executed twice
+}
+
+type Link struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+	LinkConfig
+	Disabled bool `json:"disabled"` // cut by EnableLink()
+}
+
+func (lc LinkConfig) validate() error {
+	if lc.MinDelay < 0 || lc.MaxDelay < lc.MinDelay {
+		return fmt.Errorf("bad delay range [%v, %v]", lc.MinDelay, lc.MaxDelay)
+	}
+	if lc.Drop < 0 || lc.Drop > 1 || lc.Duplicate < 0 || lc.Duplicate > 1 {
+		return fmt.Errorf("probabilities must be within [0, 1]")
+	}
+	return nil
+}
+
+// a random delay for one message on the link.
+func (lc LinkConfig) delay(rn *Network) time.Duration {
+	d := lc.MinDelay
+	if span := lc.MaxDelay - lc.MinDelay; span > 0 {
+		d += time.Duration(rn.intn(int(span) + 1))
+	}
+	return d
+}
+
+// true with probability p.
+func (rn *Network) chance(p float64) bool {
+	return p > 0 && rn.intn(1000000) < int(p*1000000)
+}
+
+// set the conditions of the link from server from to server to.
+func (rn *Network) SetLink(from interface{}, to interface{}, lc LinkConfig) error {
+	if err := lc.validate(); err != nil {
+		return err
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.links[linkKey{from, to}] = lc
+	return nil
+}
+
+func (rn *Network) ClearLink(from interface{}, to interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	delete(rn.links, linkKey{from, to})
+}
+
+// enable or cut the link from server from to server to.
+func (rn *Network) EnableLink(from interface{}, to interface{}, enabled bool) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if enabled {
+		delete(rn.cut, linkKey{from, to})
+	} else {
+		rn.cut[linkKey{from, to}] = true
+	}
+}
+
+func (rn *Network) LinkEnabled(from interface{}, to interface{}) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return !rn.cut[linkKey{from, to}]
+}
+
+// the links that have conditions set or are disabled,
+// ordered by from and to.
+func (rn *Network) Links() []Link {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	links := []Link{}
+	for k, lc := range rn.links {
+		links = append(links, Link{From: k[0], To: k[1], LinkConfig: lc, Disabled: rn.cut[k]})
+	}
+	for k := range rn.cut {
+		if _, ok := rn.links[k]; !ok {
+			links = append(links, Link{From: k[0], To: k[1], Disabled: true})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		a := fmt.Sprint(links[i].From, " ", links[i].To)
+		b := fmt.Sprint(links[j].From, " ", links[j].To)
+		return a < b
+	})
+	return links
+}
+
+// the conditions of the link a request on endname travels on,
+// and of the link its reply comes back on; nil for perfect links.
+func (rn *Network) readLinks(endname interface{}, servername interface{}) (req *LinkConfig, rep *LinkConfig) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	owner, ok := rn.owners[endname]
+	if !ok || servername == nil {
+		return nil, nil
+	}
+	if lc, ok := rn.links[linkKey{owner, servername}]; ok {
+		req = &lc
+	}
+	if lc, ok := rn.links[linkKey{servername, owner}]; ok {
+		rep = &lc
+	}
+	return
+}
+
+// is the link a message on endname travels on cut? reply selects
+// the link back from the server. rn.mu must be held.
+func (rn *Network) cutLocked(endname interface{}, servername interface{}, reply bool) bool {
+	owner, ok := rn.owners[endname]
+	if !ok || servername == nil || owner == servername {
+		return false
+	}
+	if reply {
+		return rn.cut[linkKey{servername, owner}]
+	}
+	return rn.cut[linkKey{owner, servername}]
+}
+
+// is the way back from servername to the sender on endname cut?
+func (rn *Network) replyCut(endname interface{}, servername interface{}) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.cutLocked(endname, servername, true)
+}
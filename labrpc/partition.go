@@ -0,0 +1,94 @@
+package labrpc
+
+//
+// named partitions: split the servers into groups. RPCs between
+// servers in the same group are delivered as usual, RPCs across
+// groups are lost, as if the ends were disabled.
+//
+// net.SetOwner(endname, servername) -- the server that sends on
+//   endname. ends without an owner, e.g. the clerks of a k/v
+//   service, are not subject to partitions.
+// net.Partition(groups) -- replace the partition, e.g.
+//   {"a": {0, 1}, "b": {2, 3, 4}}. a server in no group
+//   reaches no other server. groups with no servers at all
+//   are rejected; use Heal() instead.
+// net.Heal() -- remove the partition.
+// net.Partitions() -- the current groups, nil when healed.
+//
+// a partition applies on top of Enable(): a disabled end stays
+// disabled after Heal().
+//
+
+import "fmt"
+
+// record that servername sends its RPCs on endname.
+func (rn *Network) SetOwner(endname interface{}, servername interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.owners[endname] = servername
+}
+
+// split the servers into the named groups, replacing any
+// earlier partition. a server may be in at most one group.
+func (rn *Network) Partition(groups map[string][]interface{}) error {
+	group := map[interface{}]string{}
+	partitions := map[string][]interface{}{}
+	for name, servers := range groups {
+		for _, s := range servers {
+			if g, ok := group[s]; ok {
+				return fmt.Errorf("server %v is in partitions %q and %q", s, g, name)
+			}
+			group[s] = name
+		}
+		partitions[name] = append([]interface{}{}, servers...)
+	}
+	if len(group) == 0 {
+		return fmt.Errorf("a partition needs at least one server")
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.group = group
+	rn.partitions = partitions
+	return nil
+}
+
+// remove the partition; every link that is enabled works again.
+func (rn *Network) Heal() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.group = nil
+	rn.partitions = nil
+}
+
+func (rn *Network) Partitions() map[string][]interface{} {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if rn.partitions == nil {
+		return nil
+	}
+	partitions := map[string][]interface{}{}
+	for name, servers := range rn.partitions {
+		partitions[name] = append([]interface{}{}, servers...)
+	}
+	return partitions
+}
+
+// does the partition cut endname off from servername?
+// rn.mu must be held.
+func (rn *Network) partitioned(endname interface{}, servername interface{}) bool {
+	if rn.group == nil {
+		return false
+	}
+	owner, ok := rn.owners[endname]
+	if !ok || owner == servername {
+		return false
+	}
+	from, ok1 := rn.group[owner]
+	to, ok2 := rn.group[servername]
+	return !ok1 || !ok2 || from != to
+}
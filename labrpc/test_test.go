@@ -6,6 +6,7 @@ import "sync"
 import "runtime"
 import "time"
 import "fmt"
+import "os"
 
 type JunkArgs struct {
 	X int
@@ -51,6 +52,20 @@ func (js *JunkServer) Handler5(args JunkArgs, reply *JunkReply) {
 	reply.X = "no pointer"
 }
 
+type JunkCommand struct {
+	Name string
+	N    int
+}
+
+type JunkEnvelope struct {
+	Command interface{}
+}
+
+// echoes a value carried in an interface{} field
+func (js *JunkServer) Handler6(args JunkEnvelope, reply *JunkEnvelope) {
+	reply.Command = args.Command
+}
+
 func TestBasic(t *testing.T) {
 	runtime.GOMAXPROCS(4)
 
@@ -487,6 +502,46 @@ func TestKilled(t *testing.T) {
 	}
 }
 
+func TestRegister(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	Register(JunkCommand{})
+	Register(JunkCommand{}) // registering twice is harmless
+
+	found := false
+	for _, name := range Registered() {
+		if name == "labrpc.JunkCommand" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("JunkCommand missing from %v", Registered())
+	}
+
+	rn := MakeNetwork()
+
+	e := rn.MakeEnd("end1-99")
+
+	js := &JunkServer{}
+	svc := MakeService(js)
+
+	rs := MakeServer()
+	rs.AddService(svc)
+	rn.AddServer("server99", rs)
+
+	rn.Connect("end1-99", "server99")
+	rn.Enable("end1-99", true)
+
+	{
+		args := JunkEnvelope{JunkCommand{"x", 3}}
+		reply := JunkEnvelope{}
+		e.Call("JunkServer.Handler6", args, &reply)
+		if cmd, ok := reply.Command.(JunkCommand); !ok || cmd.Name != "x" || cmd.N != 3 {
+			t.Fatalf("wrong reply from Handler6: %v", reply.Command)
+		}
+	}
+}
+
 func TestBenchmark(t *testing.T) {
 	runtime.GOMAXPROCS(4)
 
@@ -516,3 +571,400 @@ func TestBenchmark(t *testing.T) {
 	fmt.Printf("%v for %v\n", time.Since(t0), n)
 	// march 2016, rtm laptop, 22 microseconds per RPC
 }
+
+// wait until n messages are held, and return them.
+func waitHeld(t *testing.T, rn *Network, n int) []HeldMessage {
+	for iters := 0; iters < 100; iters++ {
+		if held := rn.Held(); len(held) == n {
+			return held
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %v held messages, got %v", n, rn.Held())
+	return nil
+}
+
+func TestManual(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	e := rn.MakeEnd("end1-99")
+	js := &JunkServer{}
+	rs := MakeServer()
+	rs.AddService(MakeService(js))
+	rn.AddServer("server99", rs)
+	rn.Connect("end1-99", "server99")
+	rn.Enable("end1-99", true)
+
+	rn.Manual(true)
+
+	call := func(arg int) chan bool {
+		done := make(chan bool, 1)
+		go func() {
+			reply := ""
+			ok := e.Call("JunkServer.Handler2", arg, &reply)
+			done <- ok && reply == "handler2-"+strconv.Itoa(arg)
+		}()
+		return done
+	}
+
+	done := call(111)
+	held := waitHeld(t, rn, 1)
+	req := held[0]
+	if req.Kind != HeldRequest || req.SvcMeth != "JunkServer.Handler2" || req.Body != "111" {
+		t.Fatalf("unexpected held request %+v", req)
+	}
+
+	// a duplicate is executed too, but nobody sees its reply.
+	dup, ok := rn.Duplicate(req.Id)
+	if !ok {
+		t.Fatalf("could not duplicate request")
+	}
+	waitHeld(t, rn, 2)
+
+	rn.Deliver(req.Id)
+	held = waitHeld(t, rn, 2)
+	reply := held[1]
+	if reply.Kind != HeldReply || reply.Body != "handler2-111" {
+		t.Fatalf("unexpected held reply %+v", reply)
+	}
+	select {
+	case <-done:
+		t.Fatalf("Call returned before its reply was delivered")
+	default:
+	}
+	rn.Deliver(reply.Id)
+	if !<-done {
+		t.Fatalf("wrong reply after delivery")
+	}
+
+	rn.Deliver(dup)
+	held = waitHeld(t, rn, 1)
+	rn.Drop(held[0].Id)
+
+	js.mu.Lock()
+	if len(js.log2) != 2 {
+		t.Fatalf("expected the duplicate to be executed, got %v", js.log2)
+	}
+	js.mu.Unlock()
+
+	// a dropped request fails the Call.
+	done = call(222)
+	held = waitHeld(t, rn, 1)
+	rn.Drop(held[0].Id)
+	if <-done {
+		t.Fatalf("dropped request succeeded")
+	}
+
+	// leaving manual mode delivers everything held.
+	done = call(333)
+	waitHeld(t, rn, 1)
+	rn.Manual(false)
+	if !<-done {
+		t.Fatalf("held request not delivered after leaving manual mode")
+	}
+}
+
+func TestSkewedClock(t *testing.T) {
+	base := MakeSimClock(1)
+	fast := MakeSkewedClock(base, 2)
+	slow := MakeSkewedClock(base, 0.5)
+	start := base.Now()
+
+	fastCh := fast.After(100 * time.Millisecond)
+	slowCh := slow.After(100 * time.Millisecond)
+
+	base.Advance(50 * time.Millisecond)
+	select {
+	case <-fastCh:
+	default:
+		t.Fatalf("fast clock timer did not fire after half the time")
+	}
+	select {
+	case <-slowCh:
+		t.Fatalf("slow clock timer fired early")
+	default:
+	}
+	if d := fast.Now().Sub(start); d != 100*time.Millisecond {
+		t.Fatalf("fast clock advanced %v, expected 100ms", d)
+	}
+
+	base.Advance(150 * time.Millisecond)
+	select {
+	case <-slowCh:
+	default:
+		t.Fatalf("slow clock timer did not fire after twice the time")
+	}
+
+	// changing the rate keeps the time already elapsed.
+	fast.SetRate(1)
+	base.Advance(100 * time.Millisecond)
+	if d := fast.Now().Sub(start); d != 500*time.Millisecond {
+		t.Fatalf("fast clock advanced %v, expected 500ms", d)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	for i := 0; i < 4; i++ {
+		rs := MakeServer()
+		rs.AddService(MakeService(&JunkServer{}))
+		rn.AddServer(i, rs)
+	}
+	ends := map[[2]int]*ClientEnd{}
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			name := fmt.Sprintf("end%d-%d", i, j)
+			ends[[2]int{i, j}] = rn.MakeEnd(name)
+			rn.Connect(name, j)
+			rn.Enable(name, true)
+			rn.SetOwner(name, i)
+		}
+	}
+	clerk := rn.MakeEnd("clerk-2")
+	rn.Connect("clerk-2", 2)
+	rn.Enable("clerk-2", true)
+
+	call := func(e *ClientEnd) bool {
+		reply := JunkReply{}
+		return e.Call("JunkServer.Handler4", &JunkArgs{X: 1}, &reply) && reply.X == "pointer"
+	}
+	check := func(from, to int, want bool) {
+		if got := call(ends[[2]int{from, to}]); got != want {
+			t.Fatalf("call %d -> %d: got %v, want %v", from, to, got, want)
+		}
+	}
+
+	if err := rn.Partition(map[string][]interface{}{"a": {0, 1}, "b": {1, 2}}); err == nil {
+		t.Fatalf("a server in two partitions was accepted")
+	}
+	if err := rn.Partition(map[string][]interface{}{}); err == nil {
+		t.Fatalf("a partition without servers was accepted")
+	}
+	if err := rn.Partition(map[string][]interface{}{"a": {}}); err == nil {
+		t.Fatalf("a partition without servers was accepted")
+	}
+	check(0, 2, true)
+	if err := rn.Partition(map[string][]interface{}{"a": {0, 1}, "b": {2}}); err != nil {
+		t.Fatalf("Partition: %v", err)
+	}
+	check(0, 1, true)
+	check(1, 0, true)
+	check(0, 2, false)
+	check(2, 1, false)
+	check(2, 2, true)
+	// server 3 is in no group.
+	check(3, 0, false)
+	check(2, 3, false)
+	if !call(clerk) {
+		t.Fatalf("an end without owner was partitioned")
+	}
+	if p := rn.Partitions(); len(p) != 2 || len(p["a"]) != 2 {
+		t.Fatalf("unexpected partitions %v", p)
+	}
+
+	rn.Heal()
+	check(0, 2, true)
+	check(3, 0, true)
+	if rn.Partitions() != nil {
+		t.Fatalf("partitions after Heal(): %v", rn.Partitions())
+	}
+
+	// a partition does not enable a disabled end.
+	rn.Enable("end0-1", false)
+	rn.Partition(map[string][]interface{}{"a": {0, 1}})
+	check(0, 1, false)
+}
+
+func TestLinks(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	for i := 0; i < 2; i++ {
+		rs := MakeServer()
+		rs.AddService(MakeService(&JunkServer{}))
+		rn.AddServer(i, rs)
+	}
+	ends := map[[2]int]*ClientEnd{}
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			name := fmt.Sprintf("end%d-%d", i, j)
+			ends[[2]int{i, j}] = rn.MakeEnd(name)
+			rn.Connect(name, j)
+			rn.Enable(name, true)
+			rn.SetOwner(name, i)
+		}
+	}
+	call := func(from, to int) (bool, time.Duration) {
+		start := time.Now()
+		reply := JunkReply{}
+		ok := ends[[2]int{from, to}].Call("JunkServer.Handler4", &JunkArgs{X: 1}, &reply)
+		return ok && reply.X == "pointer", time.Since(start)
+	}
+
+	if err := rn.SetLink(0, 1, LinkConfig{Drop: 2}); err == nil {
+		t.Fatalf("a drop probability of 2 was accepted")
+	}
+	if err := rn.SetLink(0, 1, LinkConfig{MinDelay: 20 * time.Millisecond, MaxDelay: 10 * time.Millisecond}); err == nil {
+		t.Fatalf("an empty delay range was accepted")
+	}
+
+	// requests wait on the link from 0 to 1, replies on the link back.
+	rn.SetLink(0, 1, LinkConfig{MinDelay: 100 * time.Millisecond, MaxDelay: 100 * time.Millisecond})
+	if ok, d := call(0, 1); !ok || d < 100*time.Millisecond {
+		t.Fatalf("call over a slow link: ok %v after %v", ok, d)
+	}
+	if ok, d := call(1, 0); !ok || d < 100*time.Millisecond {
+		t.Fatalf("call with its reply over a slow link: ok %v after %v", ok, d)
+	}
+	rn.SetLink(1, 0, LinkConfig{MinDelay: 50 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+	if ok, d := call(0, 1); !ok || d < 150*time.Millisecond {
+		t.Fatalf("call over two slow links: ok %v after %v", ok, d)
+	}
+	if len(rn.Links()) != 2 {
+		t.Fatalf("unexpected links %+v", rn.Links())
+	}
+	rn.ClearLink(0, 1)
+	rn.ClearLink(1, 0)
+	if ok, d := call(0, 1); !ok || d >= 50*time.Millisecond {
+		t.Fatalf("call over cleared links: ok %v after %v", ok, d)
+	}
+
+	rn.SetLink(0, 1, LinkConfig{Drop: 1})
+	if ok, _ := call(0, 1); ok {
+		t.Fatalf("call over a link that drops everything succeeded")
+	}
+	rn.SetLink(0, 1, LinkConfig{Drop: 0.5})
+	failed := 0
+	for i := 0; i < 200; i++ {
+		if ok, _ := call(0, 1); !ok {
+			failed++
+		}
+	}
+	if failed < 60 || failed > 140 {
+		t.Fatalf("%d of 200 calls failed with drop 0.5", failed)
+	}
+
+	// every request is executed twice, but each Call gets one reply.
+	rn.SetLink(0, 1, LinkConfig{Duplicate: 1})
+	before := rn.GetCount(1)
+	for i := 0; i < 10; i++ {
+		if ok, _ := call(0, 1); !ok {
+			t.Fatalf("call over a duplicating link failed")
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := rn.GetCount(1) - before; n != 20 {
+		t.Fatalf("server executed %d requests, expected 20", n)
+	}
+}
+
+func TestOneWayLinks(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	for i := 0; i < 2; i++ {
+		rs := MakeServer()
+		rs.AddService(MakeService(&JunkServer{}))
+		rn.AddServer(i, rs)
+	}
+	ends := map[[2]int]*ClientEnd{}
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			name := fmt.Sprintf("end%d-%d", i, j)
+			ends[[2]int{i, j}] = rn.MakeEnd(name)
+			rn.Connect(name, j)
+			rn.Enable(name, true)
+			rn.SetOwner(name, i)
+		}
+	}
+	call := func(from, to int) bool {
+		reply := JunkReply{}
+		return ends[[2]int{from, to}].Call("JunkServer.Handler4", &JunkArgs{X: 1}, &reply) && reply.X == "pointer"
+	}
+
+	// 0 can no longer send to 1, but 1 still reaches 0.
+	rn.EnableLink(0, 1, false)
+	if rn.LinkEnabled(0, 1) || !rn.LinkEnabled(1, 0) {
+		t.Fatalf("unexpected link state")
+	}
+	n0, n1 := rn.GetCount(0), rn.GetCount(1)
+	if call(0, 1) {
+		t.Fatalf("call over a cut link succeeded")
+	}
+	if rn.GetCount(1) != n1 {
+		t.Fatalf("a request over a cut link was executed")
+	}
+	// 1's request arrives, but the reply needs the cut link.
+	if call(1, 0) {
+		t.Fatalf("call whose reply needs a cut link succeeded")
+	}
+	if rn.GetCount(0) != n0+1 {
+		t.Fatalf("a request over a working link was not executed")
+	}
+	if !call(0, 0) {
+		t.Fatalf("call to itself failed")
+	}
+	if links := rn.Links(); len(links) != 1 || !links[0].Disabled {
+		t.Fatalf("unexpected links %+v", links)
+	}
+
+	rn.EnableLink(0, 1, true)
+	if !call(0, 1) || !call(1, 0) {
+		t.Fatalf("calls failed after enabling the link")
+	}
+	if len(rn.Links()) != 0 {
+		t.Fatalf("unexpected links %+v", rn.Links())
+	}
+}
+
+func TestSeed(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	// which of a sequence of calls over an unreliable network fail.
+	run := func(rn *Network) string {
+		rs := MakeServer()
+		rs.AddService(MakeService(&JunkServer{}))
+		rn.AddServer("server99", rs)
+		e := rn.MakeEnd("end1-99")
+		rn.Connect("end1-99", "server99")
+		rn.Enable("end1-99", true)
+		rn.Reliable(false)
+
+		outcome := ""
+		for i := 0; i < 40; i++ {
+			reply := JunkReply{}
+			if e.Call("JunkServer.Handler4", &JunkArgs{X: i}, &reply) {
+				outcome += "+"
+			} else {
+				outcome += "-"
+			}
+		}
+		return outcome
+	}
+
+	rn := MakeNetwork(WithSeed(42))
+	if rn.Seed() != 42 {
+		t.Fatalf("Seed() = %d, expected 42", rn.Seed())
+	}
+	a := run(rn)
+	if b := run(MakeNetwork(WithSeed(42))); a != b {
+		t.Fatalf("same seed, different outcomes:\n%s\n%s", a, b)
+	}
+	if c := run(MakeNetwork(WithSeed(43))); a == c {
+		t.Fatalf("different seeds, same outcome %s", a)
+	}
+
+	old, had := os.LookupEnv("LABRPC_SEED")
+	os.Setenv("LABRPC_SEED", "7")
+	if seed := MakeNetwork().Seed(); seed != 7 {
+		t.Fatalf("LABRPC_SEED=7 gave seed %d", seed)
+	}
+	if had {
+		os.Setenv("LABRPC_SEED", old)
+	} else {
+		os.Unsetenv("LABRPC_SEED")
+	}
+}
@@ -0,0 +1,108 @@
+package lockservice
+
+import (
+	"crypto/rand"
+	"hadoop-raft/labrpc"
+	"hadoop-raft/raft"
+	"math/big"
+	"time"
+)
+
+type Clerk struct {
+	servers  []*labrpc.ClientEnd
+	owner    string // 持有者名字
+	clientId int64  // client会话id，同时是锁的持有者标识
+	seq      int64  // 最近一次使用的请求序号
+	leader   int    // 最近一次成功请求的leader，下次优先发送给它
+}
+
+func nrand() int64 {
+	max := big.NewInt(int64(1) << 62)
+	bigx, _ := rand.Int(rand.Reader, max)
+	x := bigx.Int64()
+	return x
+}
+
+func MakeClerk(servers []*labrpc.ClientEnd, owner string) *Clerk {
+	ck := new(Clerk)
+	ck.servers = servers
+	ck.owner = owner
+	ck.clientId = nrand()
+	return ck
+}
+
+// leader返回ErrOverloaded之后，等待这么久再重试
+const overloadBackoff = 50 * time.Millisecond
+
+// nextServer 根据回复中的leader提示选择下一个要尝试的节点，没有提示时轮询
+func (ck *Clerk) nextServer(leaderId int) {
+	if leaderId != raft.NoLeader && leaderId != ck.leader && leaderId < len(ck.servers) {
+		ck.leader = leaderId
+	} else {
+		ck.leader = (ck.leader + 1) % len(ck.servers)
+	}
+}
+
+// Acquire 获取名为name的锁，租约为ttl，成功时返回fencing token。
+// 最多重试timeout时长，timeout为0表示一直重试；所有重试使用同一个序号。
+// 超时前最后一次被leader以ErrOverloaded拒绝时返回ErrOverloaded，命令过大时立即返回ErrTooLarge
+func (ck *Clerk) Acquire(name string, ttl time.Duration, timeout time.Duration) (int, string, Err) {
+	ck.seq++
+	args := AcquireArgs{Name: name, Owner: ck.owner, TTL: int64(ttl), ClientId: ck.clientId, Seq: ck.seq}
+	t0 := time.Now()
+	var last Err = ErrTimeout
+	for timeout == 0 || time.Since(t0) < timeout {
+		var reply AcquireReply
+		ok := ck.servers[ck.leader].Call("LockServer.Acquire", &args, &reply)
+		if ok && reply.Err == ErrOverloaded {
+			//leader拒绝了命令，等已有的日志提交后再向它重试
+			last = ErrOverloaded
+			time.Sleep(overloadBackoff)
+			continue
+		}
+		if ok && !reply.WrongLeader && reply.Err != ErrTimeout {
+			return reply.Token, reply.Holder, reply.Err
+		}
+		last = ErrTimeout
+		leaderId := raft.NoLeader
+		if ok {
+			leaderId = reply.LeaderId
+		}
+		ck.nextServer(leaderId)
+		if !ok || leaderId == raft.NoLeader {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return 0, "", last
+}
+
+// Release 释放token对应的锁
+func (ck *Clerk) Release(name string, token int, timeout time.Duration) Err {
+	ck.seq++
+	args := ReleaseArgs{Name: name, Token: token, ClientId: ck.clientId, Seq: ck.seq}
+	t0 := time.Now()
+	var last Err = ErrTimeout
+	for timeout == 0 || time.Since(t0) < timeout {
+		var reply ReleaseReply
+		ok := ck.servers[ck.leader].Call("LockServer.Release", &args, &reply)
+		if ok && reply.Err == ErrOverloaded {
+			//leader拒绝了命令，等已有的日志提交后再向它重试
+			last = ErrOverloaded
+			time.Sleep(overloadBackoff)
+			continue
+		}
+		if ok && !reply.WrongLeader && reply.Err != ErrTimeout {
+			return reply.Err
+		}
+		last = ErrTimeout
+		leaderId := raft.NoLeader
+		if ok {
+			leaderId = reply.LeaderId
+		}
+		ck.nextServer(leaderId)
+		if !ok || leaderId == raft.NoLeader {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return last
+}
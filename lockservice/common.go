@@ -0,0 +1,43 @@
+package lockservice
+
+const (
+	OK             = "OK"
+	ErrLocked      = "ErrLocked"    // 锁被其他client持有且租约未过期
+	ErrNotHolder   = "ErrNotHolder" // 释放锁的client不是当前持有者，或者fencing token已过期
+	ErrStaleToken  = "ErrStaleToken"
+	ErrWrongLeader = "ErrWrongLeader"
+	ErrTimeout     = "ErrTimeout"
+	ErrOverloaded  = "ErrOverloaded" // leader上未提交的日志已达上限，稍后重试
+	ErrTooLarge    = "ErrTooLarge"   // 命令超过leader允许的大小，重试也不会被接受
+)
+
+type Err string
+
+type AcquireArgs struct {
+	Name     string
+	Owner    string // 持有者名字，仅用于展示
+	TTL      int64  // 租约时长(纳秒)
+	ClientId int64  // client会话id，同时是锁的持有者标识
+	Seq      int64
+}
+
+type AcquireReply struct {
+	WrongLeader bool
+	Err         Err
+	Token       int    // fencing token，即授予锁的日志index
+	Holder      string // ErrLocked时为当前持有者
+	LeaderId    int
+}
+
+type ReleaseArgs struct {
+	Name     string
+	Token    int
+	ClientId int64
+	Seq      int64
+}
+
+type ReleaseReply struct {
+	WrongLeader bool
+	Err         Err
+	LeaderId    int
+}
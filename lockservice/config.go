@@ -0,0 +1,156 @@
+package lockservice
+
+//
+// support for lock service tester, and for the displayer's lock cluster.
+// servers are never restarted, only disconnected and reconnected.
+//
+
+import (
+	"hadoop-raft/labrpc"
+	"hadoop-raft/raft"
+	"sync"
+	"testing"
+
+	crand "crypto/rand"
+	"encoding/base64"
+)
+
+func randstring(n int) string {
+	b := make([]byte, 2*n)
+	crand.Read(b)
+	s := base64.URLEncoding.EncodeToString(b)
+	return s[0:n]
+}
+
+type config struct {
+	mu        sync.Mutex
+	t         *testing.T
+	net       *labrpc.Network
+	n         int
+	lservers  []*LockServer
+	endnames  [][]string // names of each server's sending ClientEnds
+	connected []bool     // whether each server is on the net
+	clerks    map[*Clerk][]string
+}
+
+func make_config(t *testing.T, n int, unreliable bool) *config {
+	cfg := &config{}
+	cfg.t = t
+	cfg.net = labrpc.MakeNetwork()
+	cfg.n = n
+	cfg.lservers = make([]*LockServer, cfg.n)
+	cfg.endnames = make([][]string, cfg.n)
+	cfg.connected = make([]bool, cfg.n)
+	cfg.clerks = make(map[*Clerk][]string)
+
+	// create a full set of lock servers.
+	for i := 0; i < cfg.n; i++ {
+		cfg.startServer(i)
+	}
+
+	// connect everyone
+	for i := 0; i < cfg.n; i++ {
+		cfg.connect(i)
+	}
+
+	cfg.net.Reliable(!unreliable)
+
+	return cfg
+}
+
+func (cfg *config) cleanup() {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	for i := 0; i < len(cfg.lservers); i++ {
+		if cfg.lservers[i] != nil {
+			cfg.lservers[i].Kill()
+		}
+	}
+}
+
+// enable the links between server i and the servers on the net,
+// or disable all of its links; clients follow along.
+// cfg.mu must be held.
+func (cfg *config) enableUnlocked(i int, enabled bool) {
+	for j := 0; j < cfg.n; j++ {
+		if !enabled || cfg.connected[j] {
+			cfg.net.Enable(cfg.endnames[i][j], enabled)
+			cfg.net.Enable(cfg.endnames[j][i], enabled)
+		}
+	}
+	for _, endnames := range cfg.clerks {
+		cfg.net.Enable(endnames[i], enabled)
+	}
+}
+
+// attach server i to the servers that are currently on the net.
+func (cfg *config) connect(i int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.connected[i] = true
+	cfg.enableUnlocked(i, true)
+}
+
+// detach server i from all other servers, and from clients.
+func (cfg *config) disconnect(i int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.connected[i] = false
+	cfg.enableUnlocked(i, false)
+}
+
+// Create a clerk with clerk specific server names.
+// Give it connections to all of the servers that are on the net.
+func (cfg *config) makeClient(owner string) *Clerk {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	// a fresh set of ClientEnds.
+	ends := make([]*labrpc.ClientEnd, cfg.n)
+	endnames := make([]string, cfg.n)
+	for j := 0; j < cfg.n; j++ {
+		endnames[j] = randstring(20)
+		ends[j] = cfg.net.MakeEnd(endnames[j])
+		cfg.net.Connect(endnames[j], j)
+		cfg.net.Enable(endnames[j], cfg.connected[j])
+	}
+
+	ck := MakeClerk(ends, owner)
+	cfg.clerks[ck] = endnames
+	return ck
+}
+
+func (cfg *config) startServer(i int) {
+	cfg.endnames[i] = make([]string, cfg.n)
+	ends := make([]*labrpc.ClientEnd, cfg.n)
+	for j := 0; j < cfg.n; j++ {
+		cfg.endnames[i][j] = randstring(20)
+		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
+		cfg.net.Connect(cfg.endnames[i][j], j)
+	}
+
+	ls := StartLockServer(ends, i, raft.MakePersister())
+	cfg.lservers[i] = ls
+
+	srv := labrpc.MakeServer()
+	srv.AddService(labrpc.MakeService(ls))
+	srv.AddService(labrpc.MakeService(ls.rf))
+	cfg.net.AddServer(i, srv)
+}
+
+// which server, if any, thinks it is the leader?
+func (cfg *config) Leader() (bool, int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	for i := 0; i < cfg.n; i++ {
+		if cfg.lservers[i] == nil {
+			continue
+		}
+		_, is_leader := cfg.lservers[i].rf.GetState()
+		if is_leader {
+			return true, i
+		}
+	}
+	return false, 0
+}
@@ -0,0 +1,68 @@
+package lockservice
+
+import (
+	"sort"
+	"sync"
+)
+
+// FencedStore 模拟一个被锁保护的外部存储（不在raft集群内）。
+// 它记录每个资源见过的最大fencing token，拒绝token更小的写入：
+// 当一个已经失去锁（租约过期、或者所在节点被分区）的client仍然以为自己持有锁并尝试写入时，
+// 它携带的是旧的token，会被拒绝。关闭fencing时所有写入都会被接受，用来演示数据被旧持有者覆盖。
+type FencedStore struct {
+	mu      sync.Mutex
+	highest map[string]int
+	data    map[string]Write
+	history []Write
+}
+
+type Write struct {
+	Resource string `json:"resource"`
+	Value    string `json:"value"`
+	Owner    string `json:"owner"`
+	Token    int    `json:"token"`
+	Accepted bool   `json:"accepted"`
+}
+
+func MakeFencedStore() *FencedStore {
+	return &FencedStore{
+		highest: map[string]int{},
+		data:    map[string]Write{},
+	}
+}
+
+// Write 以token写入资源，fenced为true时拒绝比见过的最大token更小的写入
+func (fs *FencedStore) Write(resource string, owner string, token int, value string, fenced bool) Err {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	w := Write{Resource: resource, Value: value, Owner: owner, Token: token}
+	err := Err(OK)
+	if fenced && token < fs.highest[resource] {
+		err = ErrStaleToken
+	} else {
+		w.Accepted = true
+		fs.data[resource] = w
+		if token > fs.highest[resource] {
+			fs.highest[resource] = token
+		}
+	}
+	fs.history = append(fs.history, w)
+	return err
+}
+
+// Data 返回每个资源当前的值，以及所有写入请求的历史
+func (fs *FencedStore) Data() ([]Write, []Write) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	data := make([]Write, 0, len(fs.data))
+	for _, w := range fs.data {
+		data = append(data, w)
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].Resource < data[j].Resource
+	})
+	history := make([]Write, len(fs.history))
+	copy(history, fs.history)
+	return data, history
+}
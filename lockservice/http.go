@@ -0,0 +1,190 @@
+package lockservice
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// http接口请求的最长等待时间，超时后返回ErrTimeout
+const httpTimeout = 3 * time.Second
+
+// 默认租约时长
+const defaultTTL = 5 * time.Second
+
+var (
+	serverMu  sync.Mutex // 保护下面几个变量，不在请求锁服务期间持有
+	serverCfg *config
+	clerks    map[string]*httpClerk // 每个client名字对应一个会话
+	store     *FencedStore
+)
+
+// httpClerk 一个client名字的会话。同一会话的请求依次发送，不同会话之间互不阻塞
+type httpClerk struct {
+	mu sync.Mutex
+	ck *Clerk
+}
+
+// StartNodes 初始化锁服务集群
+func StartNodes(c *gin.Context) {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	if serverCfg != nil {
+		c.JSON(200, gin.H{
+			"msg": "already started",
+		})
+		return
+	}
+	s := c.Query("servers")
+	servers, _ := strconv.ParseInt(s, 10, 64)
+	serverCfg = make_config(nil, int(servers), false)
+	clerks = map[string]*httpClerk{}
+	store = MakeFencedStore()
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// CleanNodes 删除锁服务集群的所有节点
+func CleanNodes(c *gin.Context) {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	if serverCfg != nil {
+		serverCfg.cleanup()
+	}
+	serverCfg = nil
+	clerks = nil
+	store = nil
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// DisconnectNode 断开编号为number的锁服务节点
+func DisconnectNode(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	serverCfg.disconnect(number)
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// ReconnectNode 重连编号为number的锁服务节点
+func ReconnectNode(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	serverCfg.connect(number)
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// GetState 获取编号为number的节点的raft状态以及该节点apply得到的锁表。
+// 被分区的旧leader上的锁表不会再更新，它会一直认为旧的持有者仍然持有锁
+func GetState(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	serverCfg.mu.Lock()
+	ls := serverCfg.lservers[number]
+	connected := serverCfg.connected[number]
+	serverCfg.mu.Unlock()
+
+	locks, applied, lastNow := ls.Locks()
+	c.JSON(200, gin.H{
+		"raft":      ls.Raft().Status(),
+		"connected": connected,
+		"locks":     locks,
+		"applied":   applied,
+		"lastNow":   lastNow,
+		"now":       time.Now().UnixNano(),
+	})
+}
+
+// clerkFor 返回名为client的会话，调用方使用会话前要持有它的mu
+func clerkFor(client string) *httpClerk {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	hc, ok := clerks[client]
+	if !ok {
+		hc = &httpClerk{ck: serverCfg.makeClient(client)}
+		clerks[client] = hc
+	}
+	return hc
+}
+
+// currentStore 返回当前集群的被保护存储
+func currentStore() *FencedStore {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	return store
+}
+
+// Acquire 以client的身份获取锁，可选参数ttl为租约毫秒数
+func Acquire(c *gin.Context) {
+	ttl := defaultTTL
+	if ms, err := strconv.ParseInt(c.Query("ttl"), 10, 64); err == nil {
+		ttl = time.Duration(ms) * time.Millisecond
+	}
+	hc := clerkFor(c.Query("client"))
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	token, holder, err := hc.ck.Acquire(c.Query("lock"), ttl, httpTimeout)
+	c.JSON(200, gin.H{
+		"err":    err,
+		"token":  token,
+		"holder": holder,
+		"leader": hc.ck.leader,
+	})
+}
+
+// Release 以client的身份释放token对应的锁
+func Release(c *gin.Context) {
+	token, _ := strconv.Atoi(c.Query("token"))
+	hc := clerkFor(c.Query("client"))
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	err := hc.ck.Release(c.Query("lock"), token, httpTimeout)
+	c.JSON(200, gin.H{
+		"err":    err,
+		"leader": hc.ck.leader,
+	})
+}
+
+// WriteResource 以client的身份带着fencing token写入被保护的存储，fenced=false时存储不检查token
+func WriteResource(c *gin.Context) {
+	token, _ := strconv.Atoi(c.Query("token"))
+	fenced := c.Query("fenced") != "false"
+	err := currentStore().Write(c.Query("resource"), c.Query("client"), token, c.Query("value"), fenced)
+	c.JSON(200, gin.H{
+		"err": err,
+	})
+}
+
+// GetStore 获取被保护存储的当前数据以及写入历史
+func GetStore(c *gin.Context) {
+	data, history := currentStore().Data()
+	c.JSON(200, gin.H{
+		"data":    data,
+		"history": history,
+	})
+}
+
+// Routes 注册锁服务的http接口
+func Routes(r *gin.Engine) {
+	r.GET("/api/lock/startnodes", StartNodes)
+	r.GET("/api/lock/cleannodes", CleanNodes)
+	r.GET("/api/lock/disconnect", DisconnectNode)
+	r.GET("/api/lock/reconnect", ReconnectNode)
+	r.GET("/api/lock/getstate", GetState)
+	r.GET("/api/lock/acquire", Acquire)
+	r.GET("/api/lock/release", Release)
+	r.GET("/api/lock/write", WriteResource)
+	r.GET("/api/lock/store", GetStore)
+}
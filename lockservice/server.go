@@ -0,0 +1,245 @@
+package lockservice
+
+import (
+	"hadoop-raft/labrpc"
+	"hadoop-raft/raft"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	OpAcquire = "Acquire"
+	OpRelease = "Release"
+)
+
+// 等待一条命令被apply的最长时间，超时后client应重试
+const applyTimeout = 500 * time.Millisecond
+
+// 锁的状态完全由已提交的日志决定：租约的起点是写入日志的Now（收到请求的节点的本地时间，
+// 开启转发时可能是follower的时钟），各节点按日志顺序判断租约是否过期，所以对同一条日志会得到相同的结果。
+// 授予锁的日志index单调递增，直接作为fencing token。
+type Op struct {
+	Type     string
+	Name     string
+	Owner    string
+	TTL      int64
+	Token    int
+	Now      int64 // 收到请求的节点提交命令时的本地时间(UnixNano)
+	ClientId int64
+	Seq      int64
+}
+
+type Lock struct {
+	Name      string `json:"name"`
+	Owner     string `json:"owner"`
+	ClientId  int64  `json:"clientId"`
+	Token     int    `json:"token"`     // fencing token
+	ExpiresAt int64  `json:"expiresAt"` // 租约到期时间(UnixNano)
+}
+
+// 某个index上被apply的命令及其结果
+type applyResult struct {
+	op     Op
+	err    Err
+	token  int
+	holder string
+}
+
+type LockServer struct {
+	mu      sync.Mutex
+	me      int
+	rf      *raft.Raft
+	applyCh chan raft.ApplyMsg
+	dead    int32
+
+	locks       map[string]*Lock         // 已apply的锁表
+	results     map[int64]applyResult    // 每个client最近一次命令的结果，重复请求直接返回它
+	lastApplied int                      // 最近一次apply的日志index
+	lastNow     int64                    // 最近一次apply的命令时间，用于展示租约是否过期
+	waiters     map[int]chan applyResult // 等待某个index被apply的handler
+}
+
+func (ls *LockServer) Acquire(args *AcquireArgs, reply *AcquireReply) {
+	op := Op{Type: OpAcquire, Name: args.Name, Owner: args.Owner, TTL: args.TTL,
+		ClientId: args.ClientId, Seq: args.Seq}
+	res, wrongLeader, leaderId := ls.submit(op)
+	reply.WrongLeader = wrongLeader
+	reply.LeaderId = leaderId
+	reply.Err = res.err
+	reply.Token = res.token
+	reply.Holder = res.holder
+}
+
+func (ls *LockServer) Release(args *ReleaseArgs, reply *ReleaseReply) {
+	op := Op{Type: OpRelease, Name: args.Name, Token: args.Token,
+		ClientId: args.ClientId, Seq: args.Seq}
+	res, wrongLeader, leaderId := ls.submit(op)
+	reply.WrongLeader = wrongLeader
+	reply.LeaderId = leaderId
+	reply.Err = res.err
+}
+
+// submit 把命令交给raft，并等待它在同一个index上被apply。
+// Now取本节点的时钟，不一定是leader的：开启转发时follower的时钟决定租约何时过期
+func (ls *LockServer) submit(op Op) (applyResult, bool, int) {
+	op.Now = time.Now().UnixNano()
+	p := ls.rf.Propose(op.ClientId, op.Seq, op)
+	//leader超过提交限制时明确拒绝，命令没有进入日志
+	switch p.Err {
+	case "":
+	case raft.ErrOverloaded:
+		return applyResult{err: ErrOverloaded}, false, ls.rf.GetLeader()
+	case raft.ErrTooLarge:
+		return applyResult{err: ErrTooLarge}, false, ls.rf.GetLeader()
+	default:
+		return applyResult{err: ErrWrongLeader}, true, ls.rf.GetLeader()
+	}
+	index := p.Index
+
+	ls.mu.Lock()
+	ch := make(chan applyResult, 1)
+	ls.waiters[index] = ch
+	ls.mu.Unlock()
+
+	defer func() {
+		ls.mu.Lock()
+		if ls.waiters[index] == ch {
+			delete(ls.waiters, index)
+		}
+		ls.mu.Unlock()
+	}()
+
+	select {
+	case res := <-ch:
+		//该index上apply的不是我们提交的命令，说明leader已经更换
+		if res.op.ClientId != op.ClientId || res.op.Seq != op.Seq {
+			return applyResult{err: ErrWrongLeader}, true, ls.rf.GetLeader()
+		}
+		return res, false, ls.rf.GetLeader()
+	case <-time.After(applyTimeout):
+		return applyResult{err: ErrTimeout}, false, ls.rf.GetLeader()
+	}
+}
+
+// applyOp 在index处应用一条命令，调用方持有ls.mu
+func (ls *LockServer) applyOp(index int, op Op) applyResult {
+	res := applyResult{op: op, err: OK}
+	l, held := ls.locks[op.Name]
+	//租约是否过期只看日志中的时间，保证各节点判断一致
+	if held && op.Now >= l.ExpiresAt {
+		delete(ls.locks, op.Name)
+		held = false
+	}
+
+	switch op.Type {
+	case OpAcquire:
+		if held && l.ClientId != op.ClientId {
+			res.err = ErrLocked
+			res.holder = l.Owner
+		} else if held {
+			//持有者续约，token保持不变
+			l.ExpiresAt = op.Now + op.TTL
+			res.token = l.Token
+		} else {
+			ls.locks[op.Name] = &Lock{
+				Name:      op.Name,
+				Owner:     op.Owner,
+				ClientId:  op.ClientId,
+				Token:     index,
+				ExpiresAt: op.Now + op.TTL,
+			}
+			res.token = index
+		}
+	case OpRelease:
+		if held && l.ClientId == op.ClientId && l.Token == op.Token {
+			delete(ls.locks, op.Name)
+		} else {
+			res.err = ErrNotHolder
+		}
+	}
+	return res
+}
+
+// applier 按顺序把raft提交的命令应用到锁表上
+func (ls *LockServer) applier() {
+	for m := range ls.applyCh {
+		if ls.killed() {
+			continue
+		}
+		op, ok := m.Command.(Op)
+		if !ok {
+			continue
+		}
+
+		ls.mu.Lock()
+		var res applyResult
+		if m.Duplicate {
+			//client重试的请求已经生效过，返回第一次的结果
+			res = ls.results[op.ClientId]
+		} else {
+			res = ls.applyOp(m.Index, op)
+			ls.results[op.ClientId] = res
+		}
+		ls.lastApplied = m.Index
+		if op.Now > ls.lastNow {
+			ls.lastNow = op.Now
+		}
+
+		if ch, ok := ls.waiters[m.Index]; ok {
+			ch <- res
+			delete(ls.waiters, m.Index)
+		}
+		ls.mu.Unlock()
+	}
+}
+
+// Locks 返回该节点已apply的锁表（按名字排序），对应的日志index，以及最近一次apply的命令时间
+func (ls *LockServer) Locks() ([]Lock, int, int64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	locks := make([]Lock, 0, len(ls.locks))
+	for _, l := range ls.locks {
+		locks = append(locks, *l)
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].Name < locks[j].Name
+	})
+	return locks, ls.lastApplied, ls.lastNow
+}
+
+// Raft 返回底层的raft节点
+func (ls *LockServer) Raft() *raft.Raft {
+	return ls.rf
+}
+
+func (ls *LockServer) Kill() {
+	atomic.StoreInt32(&ls.dead, 1)
+	ls.rf.Kill()
+}
+
+func (ls *LockServer) killed() bool {
+	return atomic.LoadInt32(&ls.dead) == 1
+}
+
+// servers[] contains the ports of the set of
+// servers that will cooperate via Raft to
+// form the fault-tolerant lock service.
+// me is the index of the current server in servers[].
+func StartLockServer(servers []*labrpc.ClientEnd, me int, persister *raft.Persister) *LockServer {
+	labrpc.Register(Op{})
+
+	ls := new(LockServer)
+	ls.me = me
+	ls.locks = map[string]*Lock{}
+	ls.results = map[int64]applyResult{}
+	ls.waiters = map[int]chan applyResult{}
+
+	ls.applyCh = make(chan raft.ApplyMsg)
+	ls.rf = raft.Make(servers, me, persister, ls.applyCh)
+
+	go ls.applier()
+
+	return ls
+}
@@ -0,0 +1,140 @@
+package lockservice
+
+import "testing"
+import "time"
+import "fmt"
+import "hadoop-raft/raft"
+
+const timeout = 5 * time.Second
+
+func TestAcquireRelease(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false)
+	defer cfg.cleanup()
+
+	a := cfg.makeClient("a")
+	b := cfg.makeClient("b")
+
+	fmt.Printf("Test: acquire, conflict, release ...\n")
+
+	token1, _, err := a.Acquire("L", time.Minute, timeout)
+	if err != OK {
+		t.Fatalf("a could not acquire free lock: %v", err)
+	}
+
+	if _, holder, err := b.Acquire("L", time.Minute, timeout); err != ErrLocked || holder != "a" {
+		t.Fatalf("expected ErrLocked held by a, got %v %v", err, holder)
+	}
+
+	// renewing keeps the fencing token.
+	if token, _, err := a.Acquire("L", time.Minute, timeout); err != OK || token != token1 {
+		t.Fatalf("renew: expected token %v, got %v %v", token1, token, err)
+	}
+
+	if err := b.Release("L", token1, timeout); err != ErrNotHolder {
+		t.Fatalf("b released a's lock: %v", err)
+	}
+	if err := a.Release("L", token1, timeout); err != OK {
+		t.Fatalf("a could not release: %v", err)
+	}
+
+	token2, _, err := b.Acquire("L", time.Minute, timeout)
+	if err != OK || token2 <= token1 {
+		t.Fatalf("expected b to acquire with token > %v, got %v %v", token1, token2, err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestFencingPartitionedLeader(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false)
+	defer cfg.cleanup()
+
+	a := cfg.makeClient("a")
+	b := cfg.makeClient("b")
+	store := MakeFencedStore()
+
+	fmt.Printf("Test: fencing tokens reject a stale holder behind a partition ...\n")
+
+	ttl := time.Second
+	token1, _, err := a.Acquire("L", ttl, timeout)
+	if err != OK {
+		t.Fatalf("a could not acquire: %v", err)
+	}
+	if err := store.Write("file", "a", token1, "a1", true); err != OK {
+		t.Fatalf("a could not write: %v", err)
+	}
+
+	// the leader that granted a's lease is partitioned away,
+	// and keeps believing a holds the lock.
+	_, oldLeader := cfg.Leader()
+	cfg.disconnect(oldLeader)
+	time.Sleep(ttl)
+
+	token2, _, err := b.Acquire("L", ttl, timeout)
+	if err != OK || token2 <= token1 {
+		t.Fatalf("expected b to take over expired lease with token > %v, got %v %v", token1, token2, err)
+	}
+	if err := store.Write("file", "b", token2, "b1", true); err != OK {
+		t.Fatalf("b could not write: %v", err)
+	}
+
+	locks, _, _ := cfg.lservers[oldLeader].Locks()
+	if len(locks) != 1 || locks[0].Owner != "a" {
+		t.Fatalf("expected partitioned leader to still show a as holder, got %v", locks)
+	}
+
+	// a still thinks it holds the lock; its stale token must be fenced off.
+	if err := store.Write("file", "a", token1, "a2", true); err != ErrStaleToken {
+		t.Fatalf("expected stale write to be rejected, got %v", err)
+	}
+	data, _ := store.Data()
+	if data[0].Value != "b1" {
+		t.Fatalf("expected b1 to survive, got %v", data[0].Value)
+	}
+
+	cfg.connect(oldLeader)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestOverloadedLeader(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false)
+	defer cfg.cleanup()
+
+	a := cfg.makeClient("a")
+	b := cfg.makeClient("b")
+
+	fmt.Printf("Test: a leader over its limits rejects requests ...\n")
+
+	if _, _, err := a.Acquire("L", time.Minute, timeout); err != OK {
+		t.Fatalf("a could not acquire free lock: %v", err)
+	}
+
+	// a leader cut off from its followers keeps its one allowed
+	// uncommitted entry, and refuses everything after it.
+	_, leader := cfg.Leader()
+	cfg.lservers[leader].rf.SetLimits(raft.Limits{MaxUncommittedEntries: 1})
+	for i := 0; i < nservers; i++ {
+		if i != leader {
+			cfg.disconnect(i)
+		}
+	}
+	if _, _, err := a.Acquire("M", time.Minute, applyTimeout/2); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout without a majority, got %v", err)
+	}
+	if _, _, err := b.Acquire("N", time.Minute, time.Second); err != ErrOverloaded {
+		t.Fatalf("expected ErrOverloaded, got %v", err)
+	}
+
+	for i := 0; i < nservers; i++ {
+		cfg.connect(i)
+	}
+	if _, _, err := b.Acquire("N", time.Minute, timeout); err != OK {
+		t.Fatalf("b could not acquire after the backlog committed: %v", err)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
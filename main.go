@@ -1,10 +1,14 @@
 package main
 
 import (
+	"hadoop-raft/kvraft"
+	"hadoop-raft/lockservice"
 	"hadoop-raft/raft"
 )
 
 func main() {
 	r := raft.Server()
+	kvraft.Routes(r)
+	lockservice.Routes(r)
 	r.Run(":8080")
 }
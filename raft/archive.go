@@ -0,0 +1,159 @@
+package raft
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// 归档格式的标识和版本，读取时据此判断能否识别
+const (
+	ArchiveFormat  = "hadoop-raft/persister"
+	ArchiveVersion = 1
+)
+
+// Archive 一个节点Persister内容的可移植归档：raft state和snapshot原样保存，
+// 其余字段是从raft state中解出的元数据，便于不加载就能查看归档的内容。以JSON格式读写
+type Archive struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Node      int       `json:"node"`    //导出时的节点编号
+	Servers   int       `json:"servers"` //导出时集群的节点数
+	Created   time.Time `json:"created"`
+	Term      int       `json:"term"`
+	VotedFor  int       `json:"votedFor"`
+	LastIndex int       `json:"lastIndex"` //最后一条日志的index
+	LastTerm  int       `json:"lastTerm"`
+	Members   []int     `json:"members"` //空表示所有节点
+	RaftState []byte    `json:"raftState"`
+	Snapshot  []byte    `json:"snapshot"`
+	Checksum  string    `json:"checksum"` //raft state和snapshot的sha256
+}
+
+// persistedState Raft.persist()写入的内容
+type persistedState struct {
+	CurrentTerm int
+	VotedFor    int
+	Log         []LogEntry
+	Members     []int
+}
+
+// decodeRaftState 按照Raft.persist()的顺序解码raft state
+func decodeRaftState(data []byte) (persistedState, error) {
+	st := persistedState{VotedFor: -1, Log: []LogEntry{{Term: 0}}}
+	if len(data) == 0 {
+		return st, nil
+	}
+	d := gob.NewDecoder(bytes.NewBuffer(data))
+	if err := d.Decode(&st.CurrentTerm); err != nil {
+		return st, fmt.Errorf("decode term: %v", err)
+	}
+	if err := d.Decode(&st.VotedFor); err != nil {
+		return st, fmt.Errorf("decode votedFor: %v", err)
+	}
+	if err := d.Decode(&st.Log); err != nil {
+		return st, fmt.Errorf("decode log: %v", err)
+	}
+	//旧版本的状态中没有Members
+	if err := d.Decode(&st.Members); err != nil && err != io.EOF {
+		return st, fmt.Errorf("decode members: %v", err)
+	}
+	if len(st.Log) == 0 {
+		return st, fmt.Errorf("empty log")
+	}
+	return st, nil
+}
+
+func archiveChecksum(raftstate []byte, snapshot []byte) string {
+	h := sha256.New()
+	h.Write(raftstate)
+	h.Write(snapshot)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MakeArchive 导出ps的内容。node和servers是节点编号和集群大小，只作为元数据记录
+func MakeArchive(ps *Persister, node int, servers int) (*Archive, error) {
+	ps.mu.Lock()
+	raftstate := ps.raftstate
+	snapshot := ps.snapshot
+	ps.mu.Unlock()
+
+	st, err := decodeRaftState(raftstate)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{
+		Format:    ArchiveFormat,
+		Version:   ArchiveVersion,
+		Node:      node,
+		Servers:   servers,
+		Created:   time.Now(),
+		Term:      st.CurrentTerm,
+		VotedFor:  st.VotedFor,
+		LastIndex: len(st.Log) - 1,
+		LastTerm:  st.Log[len(st.Log)-1].Term,
+		Members:   st.Members,
+		RaftState: raftstate,
+		Snapshot:  snapshot,
+		Checksum:  archiveChecksum(raftstate, snapshot),
+	}, nil
+}
+
+// Validate 检查归档的格式、校验和，以及元数据是否与raft state一致
+func (a *Archive) Validate() error {
+	if a.Format != ArchiveFormat {
+		return fmt.Errorf("unknown archive format %q", a.Format)
+	}
+	if a.Version != ArchiveVersion {
+		return fmt.Errorf("unsupported archive version %d", a.Version)
+	}
+	if a.Checksum != archiveChecksum(a.RaftState, a.Snapshot) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	st, err := decodeRaftState(a.RaftState)
+	if err != nil {
+		return err
+	}
+	if st.CurrentTerm != a.Term || len(st.Log)-1 != a.LastIndex {
+		return fmt.Errorf("metadata (term %d, last index %d) does not match raft state (term %d, last index %d)",
+			a.Term, a.LastIndex, st.CurrentTerm, len(st.Log)-1)
+	}
+	for _, m := range st.Members {
+		if m < 0 || m >= a.Servers {
+			return fmt.Errorf("member %d out of range for %d servers", m, a.Servers)
+		}
+	}
+	return nil
+}
+
+// Persister 用归档的内容构造一个新的Persister，交给Make()恢复节点
+func (a *Archive) Persister() *Persister {
+	ps := MakePersister()
+	ps.SaveRaftState(a.RaftState)
+	ps.SaveSnapshot(a.Snapshot)
+	return ps
+}
+
+// WriteArchive 以JSON格式写出归档
+func WriteArchive(w io.Writer, a *Archive) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(a)
+}
+
+// ReadArchive 读取并校验JSON格式的归档
+func ReadArchive(r io.Reader) (*Archive, error) {
+	a := &Archive{}
+	if err := json.NewDecoder(r).Decode(a); err != nil {
+		return nil, err
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
@@ -11,6 +11,7 @@ package raft
 import (
 	"hadoop-raft/labrpc"
 	"log"
+	"reflect"
 	"runtime"
 	"sync"
 	"testing"
@@ -18,10 +19,17 @@ import (
 	crand "crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"math/big"
 	"sync/atomic"
 	"time"
 )
 
+func nrand() int64 {
+	max := big.NewInt(int64(1) << 62)
+	bigx, _ := crand.Int(crand.Reader, max)
+	return bigx.Int64()
+}
+
 func randstring(n int) string {
 	b := make([]byte, 2*n)
 	crand.Read(b)
@@ -38,14 +46,31 @@ type config struct {
 	rafts     []*Raft
 	applyErr  []string // from apply channel readers
 	connected []bool   // whether each server is on the net
+	paused    []bool   // whether each server is frozen by pause()
 	saved     []*Persister
-	endnames  [][]string    // the port file names each sends to
-	logs      []map[int]int // copy of each server's committed entries
+	endnames  [][]string            // the port file names each sends to
+	logs      []map[int]interface{} // copy of each server's committed entries
+	dups      []map[int]int         // per server, index of a deduplicated retry -> index first applied
+	forward   bool                  // whether followers forward proposals to the leader
+	limits    Limits                // proposal limits of every server
+	checked   bool                  // whether every Raft checks its invariants
+	hint      int                   // the last server known to be leader, used by submit()
+	clock     labrpc.Clock          // shared by the network and every Raft
+	rates     []float64             // clock rate of each server, 1 is no skew
+	clocks    []*labrpc.SkewedClock // each server's view of clock
 }
 
 var ncpu_once sync.Once
 
 func make_config(t *testing.T, n int, unreliable bool) *config {
+	return make_config_clock(t, n, unreliable, labrpc.MakeRealClock())
+}
+
+// like make_config, but the network and the Rafts take time from
+// clock, and the Rafts their randomness. with a labrpc.SimClock
+// nothing happens until the caller advances the clock, and the
+// network is seeded with the clock's seed unless opts say otherwise.
+func make_config_clock(t *testing.T, n int, unreliable bool, clock labrpc.Clock, opts ...labrpc.NetworkOption) *config {
 	ncpu_once.Do(func() {
 		if runtime.NumCPU() < 2 {
 			fmt.Printf("warning: only one CPU, which may conceal locking bugs\n")
@@ -54,14 +79,28 @@ func make_config(t *testing.T, n int, unreliable bool) *config {
 	runtime.GOMAXPROCS(4)
 	cfg := &config{}
 	cfg.t = t
-	cfg.net = labrpc.MakeNetwork()
+	if sim, ok := clock.(*labrpc.SimClock); ok {
+		opts = append([]labrpc.NetworkOption{labrpc.WithSeed(sim.Seed())}, opts...)
+	}
+	cfg.net = labrpc.MakeNetwork(opts...)
+	cfg.net.SetClock(clock)
+	cfg.clock = clock
 	cfg.n = n
 	cfg.applyErr = make([]string, cfg.n)
 	cfg.rafts = make([]*Raft, cfg.n)
 	cfg.connected = make([]bool, cfg.n)
+	cfg.paused = make([]bool, cfg.n)
+	cfg.rates = make([]float64, cfg.n)
+	cfg.clocks = make([]*labrpc.SkewedClock, cfg.n)
+	for i := range cfg.rates {
+		cfg.rates[i] = 1
+	}
 	cfg.saved = make([]*Persister, cfg.n)
 	cfg.endnames = make([][]string, cfg.n)
-	cfg.logs = make([]map[int]int, cfg.n)
+	cfg.logs = make([]map[int]interface{}, cfg.n)
+	cfg.dups = make([]map[int]int, cfg.n)
+	// tests always run with invariant checks.
+	cfg.checked = t != nil
 
 	cfg.setunreliable(unreliable)
 
@@ -69,7 +108,8 @@ func make_config(t *testing.T, n int, unreliable bool) *config {
 
 	// create a full set of Rafts.
 	for i := 0; i < cfg.n; i++ {
-		cfg.logs[i] = map[int]int{}
+		cfg.logs[i] = map[int]interface{}{}
+		cfg.dups[i] = map[int]int{}
 		cfg.start1(i)
 	}
 
@@ -101,15 +141,80 @@ func (cfg *config) crash1(i int) {
 	if rf != nil {
 		cfg.mu.Unlock()
 		rf.Kill()
+		cfg.checkViolation(rf)
 		cfg.mu.Lock()
 		cfg.rafts[i] = nil
 	}
 
 	if cfg.saved[i] != nil {
 		raftlog := cfg.saved[i].ReadRaftState()
+		snapshot := cfg.saved[i].ReadSnapshot()
 		cfg.saved[i] = &Persister{}
 		cfg.saved[i].SaveRaftState(raftlog)
+		cfg.saved[i].SaveSnapshot(snapshot)
+	}
+}
+
+// export server i's persisted state as an archive.
+func (cfg *config) backup(i int) (*Archive, error) {
+	cfg.mu.Lock()
+	ps := cfg.saved[i]
+	cfg.mu.Unlock()
+	if ps == nil {
+		return nil, fmt.Errorf("server %d has never been started", i)
+	}
+	return MakeArchive(ps, i, cfg.n)
+}
+
+// replace server i's persisted state with archive a and re-start
+// it through start1(). like start1(), leaves it disconnected.
+// forgets what server i had applied, so that replaying the
+// archived log isn't checked against i's own history; it is
+// still checked against the other servers.
+func (cfg *config) restore(i int, a *Archive) error {
+	if a.Servers != cfg.n {
+		return fmt.Errorf("archive is from a %d server cluster, not %d", a.Servers, cfg.n)
+	}
+	cfg.crash1(i)
+	cfg.mu.Lock()
+	cfg.saved[i] = a.Persister()
+	cfg.logs[i] = map[int]interface{}{}
+	cfg.dups[i] = map[int]int{}
+	cfg.applyErr[i] = ""
+	cfg.mu.Unlock()
+	cfg.start1(i)
+	return nil
+}
+
+// restore every server from archives[i], e.g. a saved cluster
+// state, and connect them. the whole history is forgotten, so
+// the restored logs need only agree with each other.
+func (cfg *config) restoreAll(archives []*Archive) error {
+	if len(archives) != cfg.n {
+		return fmt.Errorf("%d archives for %d servers", len(archives), cfg.n)
+	}
+	for i := 0; i < cfg.n; i++ {
+		if archives[i].Servers != cfg.n {
+			return fmt.Errorf("archive is from a %d server cluster, not %d", archives[i].Servers, cfg.n)
+		}
+	}
+	for i := 0; i < cfg.n; i++ {
+		cfg.crash1(i)
 	}
+	cfg.mu.Lock()
+	for i := 0; i < cfg.n; i++ {
+		cfg.logs[i] = map[int]interface{}{}
+		cfg.dups[i] = map[int]int{}
+		cfg.applyErr[i] = ""
+	}
+	cfg.mu.Unlock()
+	for i := 0; i < cfg.n; i++ {
+		if err := cfg.restore(i, archives[i]); err != nil {
+			return err
+		}
+		cfg.connect(i)
+	}
+	return nil
 }
 
 //
@@ -134,6 +239,7 @@ func (cfg *config) start1(i int) {
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
 		cfg.net.Connect(cfg.endnames[i][j], j)
+		cfg.net.SetOwner(cfg.endnames[i][j], i)
 	}
 
 	cfg.mu.Lock()
@@ -157,10 +263,11 @@ func (cfg *config) start1(i int) {
 			err_msg := ""
 			if m.UseSnapshot {
 				// ignore the snapshot
-			} else if v, ok := (m.Command).(int); ok {
+			} else {
+				v := m.Command
 				cfg.mu.Lock()
 				for j := 0; j < len(cfg.logs); j++ {
-					if old, oldok := cfg.logs[j][m.Index]; oldok && old != v {
+					if old, oldok := cfg.logs[j][m.Index]; oldok && !sameCommand(old, v) {
 						// some server has already committed a different value for this entry!
 						err_msg = fmt.Sprintf("commit index=%v server=%v %v != server=%v %v",
 							m.Index, i, m.Command, j, old)
@@ -168,29 +275,49 @@ func (cfg *config) start1(i int) {
 				}
 				_, prevok := cfg.logs[i][m.Index-1]
 				cfg.logs[i][m.Index] = v
+				if m.Duplicate {
+					cfg.dups[i][m.Index] = m.DuplicateOf
+				}
 				cfg.mu.Unlock()
 
 				if m.Index > 1 && prevok == false {
 					err_msg = fmt.Sprintf("server %v apply out of order %v", i, m.Index)
 				}
-			} else {
-				err_msg = fmt.Sprintf("committed command %v is not an int", m.Command)
 			}
 
 			if err_msg != "" {
-				log.Fatalf("apply error: %v\n", err_msg)
+				if cfg.t != nil {
+					log.Fatalf("apply error: %v\n", err_msg)
+				}
+				// the displayer must survive a bad apply; record
+				// the error so that it shows up in the node's state.
+				log.Printf("apply error: %v\n", err_msg)
+				cfg.mu.Lock()
 				cfg.applyErr[i] = err_msg
+				cfg.mu.Unlock()
 				// keep reading after error so that Raft doesn't block
 				// holding locks...
 			}
 		}
 	}()
 
-	rf := Make(ends, i, cfg.saved[i], applyCh)
+	cfg.mu.Lock()
+	clock := labrpc.MakeSkewedClock(cfg.clock, cfg.rates[i])
+	cfg.clocks[i] = clock
+	cfg.mu.Unlock()
+
+	rf := MakeWithClock(ends, i, cfg.saved[i], applyCh, clock)
 
 	cfg.mu.Lock()
 	cfg.rafts[i] = rf
+	cfg.paused[i] = false // a restarted server starts running
+	forward := cfg.forward
+	limits := cfg.limits
+	checked := cfg.checked
 	cfg.mu.Unlock()
+	rf.SetForwarding(forward)
+	rf.SetLimits(limits)
+	rf.SetChecked(checked)
 
 	svc := labrpc.MakeService(rf)
 	srv := labrpc.MakeServer()
@@ -205,6 +332,46 @@ func (cfg *config) cleanup() {
 		}
 	}
 	atomic.StoreInt32(&cfg.done, 1)
+	for i := 0; i < len(cfg.rafts); i++ {
+		if cfg.rafts[i] != nil {
+			cfg.checkViolation(cfg.rafts[i])
+		}
+	}
+}
+
+// fail the test if rf stopped on a broken invariant. the
+// displayer shows the report in the node's state instead.
+func (cfg *config) checkViolation(rf *Raft) {
+	if v := rf.Violation(); v != "" && cfg.t != nil {
+		cfg.t.Fatalf("%v", v)
+	}
+}
+
+// fail as soon as any server stopped on a broken invariant, rather
+// than waiting for agreement that the stopped server blocks.
+func (cfg *config) checkViolations() {
+	cfg.mu.Lock()
+	rafts := append([]*Raft{}, cfg.rafts...)
+	cfg.mu.Unlock()
+	for _, rf := range rafts {
+		if rf != nil {
+			cfg.checkViolation(rf)
+		}
+	}
+}
+
+// turn invariant checks on or off, on every server now and on
+// servers restarted later; see Raft.SetChecked().
+func (cfg *config) setchecked(on bool) {
+	cfg.mu.Lock()
+	cfg.checked = on
+	rafts := append([]*Raft{}, cfg.rafts...)
+	cfg.mu.Unlock()
+	for _, rf := range rafts {
+		if rf != nil {
+			rf.SetChecked(on)
+		}
+	}
 }
 
 // attach server i to the net.
@@ -253,6 +420,232 @@ func (cfg *config) disconnect(i int) {
 	}
 }
 
+// split the servers into named groups that only reach servers in
+// the same group; see labrpc's Partition(). unlike disconnect(),
+// connected[] is left alone, so both sides count as connected.
+func (cfg *config) partition(groups map[string][]int) error {
+	netGroups := map[string][]interface{}{}
+	for name, servers := range groups {
+		netGroups[name] = []interface{}{}
+		for _, i := range servers {
+			if i < 0 || i >= cfg.n {
+				return fmt.Errorf("no server %d", i)
+			}
+			netGroups[name] = append(netGroups[name], i)
+		}
+	}
+	return cfg.net.Partition(netGroups)
+}
+
+// remove the partition.
+func (cfg *config) heal() {
+	cfg.net.Heal()
+}
+
+// the partition server i is in, or "" if the network is not
+// partitioned or i is in no group.
+func (cfg *config) partitionOf(i int) string {
+	for name, servers := range cfg.net.Partitions() {
+		for _, s := range servers {
+			if s == i {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// set the conditions of the link from server i to server j; see
+// labrpc's SetLink(). links are between servers, so they survive
+// restarts.
+func (cfg *config) setlink(i int, j int, lc labrpc.LinkConfig) error {
+	if i < 0 || i >= cfg.n || j < 0 || j >= cfg.n {
+		return fmt.Errorf("no link from %d to %d", i, j)
+	}
+	return cfg.net.SetLink(i, j, lc)
+}
+
+// make the link from server i to server j perfect again.
+func (cfg *config) clearlink(i int, j int) {
+	cfg.net.ClearLink(i, j)
+}
+
+// cut or restore the one-way link from server i to server j:
+// while it is cut, i's requests to j and i's replies to j are
+// lost, but j still reaches i. disconnect() cuts both directions.
+func (cfg *config) enablelink(i int, j int, enabled bool) error {
+	if i < 0 || i >= cfg.n || j < 0 || j >= cfg.n {
+		return fmt.Errorf("no link from %d to %d", i, j)
+	}
+	cfg.net.EnableLink(i, j, enabled)
+	return nil
+}
+
+// server i can send but no longer receives anything.
+func (cfg *config) cutincoming(i int) {
+	for j := 0; j < cfg.n; j++ {
+		if j != i {
+			cfg.net.EnableLink(j, i, false)
+		}
+	}
+}
+
+// server i receives but can no longer send, not even replies.
+func (cfg *config) cutoutgoing(i int) {
+	for j := 0; j < cfg.n; j++ {
+		if j != i {
+			cfg.net.EnableLink(i, j, false)
+		}
+	}
+}
+
+// restore every link to and from server i.
+func (cfg *config) restorelinks(i int) {
+	for j := 0; j < cfg.n; j++ {
+		cfg.net.EnableLink(i, j, true)
+		cfg.net.EnableLink(j, i, true)
+	}
+}
+
+// a message held by the network in manual mode, with the
+// servers it travels between.
+type heldMessage struct {
+	labrpc.HeldMessage
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// the messages held by the network, labeled with sender and
+// receiver. a reply travels from the server back to the caller.
+func (cfg *config) held() []heldMessage {
+	owners := map[interface{}][2]int{}
+	cfg.mu.Lock()
+	for i := range cfg.endnames {
+		for j, name := range cfg.endnames[i] {
+			owners[name] = [2]int{i, j}
+		}
+	}
+	cfg.mu.Unlock()
+
+	var msgs []heldMessage
+	for _, m := range cfg.net.Held() {
+		hm := heldMessage{HeldMessage: m, From: -1, To: -1}
+		if o, ok := owners[m.EndName]; ok {
+			hm.From, hm.To = o[0], o[1]
+			if m.Kind == labrpc.HeldReply {
+				hm.From, hm.To = o[1], o[0]
+			}
+		}
+		msgs = append(msgs, hm)
+	}
+	return msgs
+}
+
+// make server i's clock run at rate times the shared clock,
+// now and after restarts.
+func (cfg *config) setrate(i int, rate float64) {
+	cfg.mu.Lock()
+	cfg.rates[i] = rate
+	clock := cfg.clocks[i]
+	cfg.mu.Unlock()
+	if clock != nil {
+		clock.SetRate(rate)
+	}
+}
+
+// unsafely turn the servers in members into a new, smaller
+// cluster that keeps their logs; see Raft.ForceNewCluster().
+// every member is checked before any server is rewritten, and
+// every other server must be crashed or disconnected: removed
+// servers still have the old configuration, and could elect a
+// leader of their own. the caller must keep them off the net.
+// returns the servers that were rewritten.
+func (cfg *config) forceNewCluster(members []int) ([]int, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no members")
+	}
+	cfg.mu.Lock()
+	rafts := make([]*Raft, len(members))
+	seen := map[int]bool{}
+	for j, i := range members {
+		if i < 0 || i >= cfg.n {
+			cfg.mu.Unlock()
+			return nil, fmt.Errorf("node %d does not exist", i)
+		}
+		if seen[i] {
+			cfg.mu.Unlock()
+			return nil, fmt.Errorf("node %d is listed twice", i)
+		}
+		seen[i] = true
+		if cfg.rafts[i] == nil {
+			cfg.mu.Unlock()
+			return nil, fmt.Errorf("node %d is not running", i)
+		}
+		rafts[j] = cfg.rafts[i]
+	}
+	for i := 0; i < cfg.n; i++ {
+		if !seen[i] && cfg.connected[i] {
+			cfg.mu.Unlock()
+			return nil, fmt.Errorf("node %d is not a member but still connected", i)
+		}
+	}
+	cfg.mu.Unlock()
+
+	rewritten := []int{}
+	for j, rf := range rafts {
+		if !rf.ForceNewCluster(members) {
+			return rewritten, fmt.Errorf("node %d refused the new cluster", members[j])
+		}
+		rewritten = append(rewritten, members[j])
+	}
+	return rewritten, nil
+}
+
+// freeze server i, leaving its links up; see Raft.Pause().
+func (cfg *config) pause(i int) {
+	cfg.mu.Lock()
+	rf := cfg.rafts[i]
+	cfg.paused[i] = true
+	cfg.mu.Unlock()
+	if rf != nil {
+		rf.Pause()
+	}
+}
+
+func (cfg *config) resume(i int) {
+	cfg.mu.Lock()
+	rf := cfg.rafts[i]
+	cfg.paused[i] = false
+	cfg.mu.Unlock()
+	if rf != nil {
+		rf.Resume()
+	}
+}
+
+// read server i's applied state without going through the log,
+// if it is at most maxStaleness old; see Raft.StaleRead(). returns
+// the read state and the command applied at its index.
+func (cfg *config) staleRead(i int, maxStaleness time.Duration) (ReadState, interface{}) {
+	cfg.mu.Lock()
+	rf := cfg.rafts[i]
+	cfg.mu.Unlock()
+	if rf == nil {
+		return ReadState{Err: ErrNoReadProof}, nil
+	}
+	rs := rf.StaleRead(maxStaleness)
+	if rs.Err != "" || rs.Index == 0 {
+		return rs, nil
+	}
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	// the applier may not have recorded the entry yet.
+	v, ok := cfg.logs[i][rs.Index]
+	if !ok {
+		rs.Err = ErrNotApplied
+	}
+	return rs, v
+}
+
 func (cfg *config) rpcCount(server int) int {
 	return cfg.net.GetCount(server)
 }
@@ -261,6 +654,34 @@ func (cfg *config) setunreliable(unrel bool) {
 	cfg.net.Reliable(!unrel)
 }
 
+// turn forwarding of proposals on or off, on every server
+// now and on servers restarted later.
+func (cfg *config) setforwarding(on bool) {
+	cfg.mu.Lock()
+	cfg.forward = on
+	rafts := append([]*Raft{}, cfg.rafts...)
+	cfg.mu.Unlock()
+	for _, rf := range rafts {
+		if rf != nil {
+			rf.SetForwarding(on)
+		}
+	}
+}
+
+// set the proposal limits of every server, now and on servers
+// restarted later.
+func (cfg *config) setlimits(l Limits) {
+	cfg.mu.Lock()
+	cfg.limits = l
+	rafts := append([]*Raft{}, cfg.rafts...)
+	cfg.mu.Unlock()
+	for _, rf := range rafts {
+		if rf != nil {
+			rf.SetLimits(l)
+		}
+	}
+}
+
 func (cfg *config) setlongreordering(longrel bool) {
 	cfg.net.LongReordering(longrel)
 }
@@ -270,6 +691,7 @@ func (cfg *config) setlongreordering(longrel bool) {
 func (cfg *config) checkOneLeader() int {
 	for iters := 0; iters < 10; iters++ {
 		time.Sleep(500 * time.Millisecond)
+		cfg.checkViolations()
 		leaders := make(map[int][]int)
 		for i := 0; i < cfg.n; i++ {
 			if cfg.connected[i] {
@@ -325,10 +747,17 @@ func (cfg *config) checkNoLeader() {
 	}
 }
 
+// committed commands may be of any type, e.g. a struct with a
+// slice in it, which == would panic on.
+func sameCommand(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
 // how many servers think a log entry is committed?
 func (cfg *config) nCommitted(index int) (int, interface{}) {
 	count := 0
-	cmd := -1
+	var cmd interface{} = -1
+	cfg.checkViolations()
 	for i := 0; i < len(cfg.rafts); i++ {
 		if cfg.applyErr[i] != "" {
 			cfg.t.Fatal(cfg.applyErr[i])
@@ -339,7 +768,7 @@ func (cfg *config) nCommitted(index int) (int, interface{}) {
 		cfg.mu.Unlock()
 
 		if ok {
-			if count > 0 && cmd != cmd1 {
+			if count > 0 && !sameCommand(cmd, cmd1) {
 				cfg.t.Fatalf("committed values do not match: index %v, %v, %v\n",
 					index, cmd, cmd1)
 			}
@@ -350,6 +779,73 @@ func (cfg *config) nCommitted(index int) (int, interface{}) {
 	return count, cmd
 }
 
+// one node submit() tried, and what it said.
+type submitAttempt struct {
+	Number    int    `json:"number"`
+	Connected bool   `json:"connected"`
+	Term      int    `json:"term"`
+	LeaderId  int    `json:"leaderId"`
+	Err       string `json:"err"`
+}
+
+// propose cmd to whichever server is leader, following the leader
+// hints that non-leaders return, and retrying across elections
+// until timeout. returns the accepted proposal (p.Err is "timeout"
+// if none, or the leader's reason for refusing it), the server that
+// accepted it, and every attempt made.
+func (cfg *config) submit(clientId int64, seq int64, cmd interface{}, timeout time.Duration) (Proposal, int, []submitAttempt) {
+	var attempts []submitAttempt
+	cfg.mu.Lock()
+	next := cfg.hint
+	cfg.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for tried := 0; time.Now().Before(deadline); tried++ {
+		// after a full round without a leader, give the election time.
+		if tried > 0 && tried%cfg.n == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		i := next
+		next = (i + 1) % cfg.n
+
+		cfg.mu.Lock()
+		rf := cfg.rafts[i]
+		connected := cfg.connected[i]
+		paused := cfg.paused[i]
+		cfg.mu.Unlock()
+		if rf == nil || !connected {
+			attempts = append(attempts, submitAttempt{Number: i, Term: -1, LeaderId: NoLeader, Err: "disconnected"})
+			continue
+		}
+		if paused {
+			// a frozen server would not answer.
+			attempts = append(attempts, submitAttempt{Number: i, Connected: true, Term: -1, LeaderId: NoLeader, Err: "paused"})
+			continue
+		}
+
+		p := rf.Propose(clientId, seq, cmd)
+		attempts = append(attempts, submitAttempt{i, true, p.Term, p.LeaderId, p.Err})
+		if p.Err == "" {
+			leader := i
+			if p.Forwarded {
+				leader = p.LeaderId
+			}
+			cfg.mu.Lock()
+			cfg.hint = leader
+			cfg.mu.Unlock()
+			return p, leader, attempts
+		}
+		if p.Err == ErrTooLarge || p.Err == ErrOverloaded {
+			// the leader refused it; retrying elsewhere won't help.
+			return p, p.LeaderId, attempts
+		}
+		if p.LeaderId != NoLeader && p.LeaderId != i {
+			next = p.LeaderId
+		}
+	}
+	return Proposal{Index: -1, Term: -1, LeaderId: NoLeader, Err: "timeout"}, NoLeader, attempts
+}
+
 // wait for at least n servers to commit.
 // but don't wait forever.
 func (cfg *config) wait(index int, n int, startTerm int) interface{} {
@@ -381,6 +877,19 @@ func (cfg *config) wait(index int, n int, startTerm int) interface{} {
 	return cmd
 }
 
+// if some server applied the entry at index as a deduplicated
+// retry, return the index at which the command first took effect.
+func (cfg *config) firstApplied(index int) int {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	for i := 0; i < len(cfg.dups); i++ {
+		if first, ok := cfg.dups[i][index]; ok && first > 0 {
+			return first
+		}
+	}
+	return index
+}
+
 // do a complete agreement.
 // it might choose the wrong leader initially,
 // and have to re-submit after giving up.
@@ -388,8 +897,12 @@ func (cfg *config) wait(index int, n int, startTerm int) interface{} {
 // indirectly checks that the servers agree on the
 // same value, since nCommitted() checks this,
 // as do the threads that read from applyCh.
-// returns index.
-func (cfg *config) one(cmd int, expectedServers int) int {
+// each call is a client session of its own, so a
+// re-submitted command is applied only once, and concurrent
+// calls can't be taken for retries of each other.
+// returns the index at which the command took effect.
+func (cfg *config) one(cmd interface{}, expectedServers int) int {
+	clientId := nrand()
 	t0 := time.Now()
 	starts := 0
 	for time.Since(t0).Seconds() < 10 {
@@ -399,12 +912,12 @@ func (cfg *config) one(cmd int, expectedServers int) int {
 			starts = (starts + 1) % cfg.n
 			var rf *Raft
 			cfg.mu.Lock()
-			if cfg.connected[starts] {
+			if cfg.connected[starts] && !cfg.paused[starts] {
 				rf = cfg.rafts[starts]
 			}
 			cfg.mu.Unlock()
 			if rf != nil {
-				index1, _, ok := rf.Start(cmd)
+				index1, _, ok := rf.StartSession(clientId, 1, cmd)
 				if ok {
 					index = index1
 					break
@@ -420,9 +933,9 @@ func (cfg *config) one(cmd int, expectedServers int) int {
 				nd, cmd1 := cfg.nCommitted(index)
 				if nd > 0 && nd >= expectedServers {
 					// committed
-					if cmd2, ok := cmd1.(int); ok && cmd2 == cmd {
+					if sameCommand(cmd1, cmd) {
 						// and it was the command we submitted.
-						return index
+						return cfg.firstApplied(index)
 					}
 				}
 				time.Sleep(20 * time.Millisecond)
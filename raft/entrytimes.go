@@ -0,0 +1,33 @@
+package raft
+
+// EntryTimes 一条日志在本节点上的提交和apply时间(UnixNano)，0表示还没有发生。
+// 只保存在本地，不随日志复制；与LogEntry.Timestamp（leader收到命令的时间）相减即可得到复制和apply的耗时，
+// 时间取自各节点自己的时钟，节点间的时钟偏差会计入结果
+type EntryTimes struct {
+	Committed int64 `json:"committed"`
+	Applied   int64 `json:"applied"`
+}
+
+// timesAt 返回index处日志的时间记录，需要时扩展rf.times
+func (rf *Raft) timesAt(index int) *EntryTimes {
+	for len(rf.times) <= index {
+		rf.times = append(rf.times, EntryTimes{})
+	}
+	return &rf.times[index]
+}
+
+// commitTo 把commitIndex推进到n，并记录新提交的日志在本节点上的提交时间
+func (rf *Raft) commitTo(n int) {
+	now := rf.clock.Now().UnixNano()
+	for i := rf.commitIndex + 1; i <= n; i++ {
+		rf.timesAt(i).Committed = now
+	}
+	rf.commitIndex = n
+}
+
+// EntryTimes 返回已提交日志在本节点上的提交和apply时间，下标为日志的index
+func (rf *Raft) EntryTimes() []EntryTimes {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return append([]EntryTimes(nil), rf.times...)
+}
@@ -0,0 +1,93 @@
+package raft
+
+// Propose的错误，为空表示命令已经被某个leader接受。leader超过提交限制时为ErrTooLarge或ErrOverloaded，见limits.go
+const (
+	ErrNotLeader     = "not leader"     //本节点不是leader，且没有开启转发
+	ErrNoLeader      = "no leader"      //本节点不知道当前的leader，无法转发
+	ErrForwardFailed = "forward failed" //转发的RPC没有返回，或者对方已经不是leader
+)
+
+// Proposal 一次提交的结果。Forwarded为true时Index和Term来自leader
+type Proposal struct {
+	Index     int    `json:"index"`
+	Term      int    `json:"term"`
+	LeaderId  int    `json:"leaderId"`
+	Forwarded bool   `json:"forwarded"`
+	Err       string `json:"err"`
+}
+
+// ForwardArgs follower转发给leader的命令
+type ForwardArgs struct {
+	GroupId  int
+	From     int
+	ClientId int64
+	Seq      int64
+	Command  interface{}
+}
+
+// ForwardReply leader接受命令时返回的index和term；不是leader时给出它所知道的leader。
+// Err为leader超过提交限制时拒绝的原因
+type ForwardReply struct {
+	Index    int
+	Term     int
+	IsLeader bool
+	LeaderId int
+	Err      string
+}
+
+// SetForwarding 开启或关闭转发模式。开启后，follower上的Propose会把命令转发给所知道的leader
+func (rf *Raft) SetForwarding(on bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.forwarding = on
+}
+
+// Propose 提交一条命令。leader直接追加到自己的日志；
+// follower在转发模式下通过Forward RPC交给leader，并返回leader上的index和term。
+// clientId为NoClient时命令不属于任何会话
+func (rf *Raft) Propose(clientId int64, seq int64, command interface{}) Proposal {
+	index, term, isLeader, err := rf.start(LogEntry{Command: command, ClientId: clientId, Seq: seq, Proposer: rf.me})
+	if isLeader {
+		return Proposal{Index: index, Term: term, LeaderId: rf.me, Err: err}
+	}
+
+	rf.mu.Lock()
+	forwarding := rf.forwarding
+	leaderId := rf.leaderId
+	rf.mu.Unlock()
+
+	if !forwarding {
+		return Proposal{Index: -1, Term: term, LeaderId: leaderId, Err: ErrNotLeader}
+	}
+	if leaderId == NoLeader || leaderId == rf.me {
+		return Proposal{Index: -1, Term: term, LeaderId: NoLeader, Err: ErrNoLeader}
+	}
+
+	args := ForwardArgs{
+		GroupId:  rf.gid,
+		From:     rf.me,
+		ClientId: clientId,
+		Seq:      seq,
+		Command:  command,
+	}
+	reply := ForwardReply{}
+	if ok := rf.peers[leaderId].Call("Raft.Forward", &args, &reply); !ok {
+		return Proposal{Index: -1, Term: term, LeaderId: leaderId, Forwarded: true, Err: ErrForwardFailed}
+	}
+	if !reply.IsLeader {
+		//只转发一跳，避免在过期的leader信息之间来回转发
+		return Proposal{Index: -1, Term: reply.Term, LeaderId: reply.LeaderId, Forwarded: true, Err: ErrForwardFailed}
+	}
+	return Proposal{Index: reply.Index, Term: reply.Term, LeaderId: leaderId, Forwarded: true, Err: reply.Err}
+}
+
+// Forward leader接受follower转发来的命令，按照本地提交的方式追加到日志
+func (rf *Raft) Forward(args *ForwardArgs, reply *ForwardReply) {
+	index, term, isLeader, err := rf.start(LogEntry{Command: args.Command, ClientId: args.ClientId, Seq: args.Seq, Proposer: args.From})
+	reply.Index = index
+	reply.Term = term
+	reply.IsLeader = isLeader
+	reply.Err = err
+	reply.LeaderId = rf.GetLeader()
+	debug("====>[%d] %d server forwarded command from %d, index %d leader %v", term, rf.me, args.From, index, isLeader)
+}
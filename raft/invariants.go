@@ -0,0 +1,109 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"runtime"
+)
+
+//
+// 检查模式：每次状态变化之后检查raft自身的不变量，发现违反时记录详细的报告并让节点停止运行，
+// 而不是等到错误的日志被apply之后才在tester里暴露出来。检查的不变量：
+//   commitIndex和CurrentTerm不回退；
+//   lastApplied <= commitIndex <= 最后一条日志的index；
+//   leader在自己的term内不截断、不覆盖自己的日志；
+//   投票在返回之前已经持久化。
+// 停止后的节点像被暂停一样不再运行，正在处理的RPC也不会返回，直到被Kill
+//
+
+// invariantState 上一次检查时的状态，用于判断是否回退
+type invariantState struct {
+	commitIndex    int
+	term           int
+	leaderTerm     int //作为leader检查时的term，0表示不是leader
+	leaderLen      int //leaderTerm内leader日志的长度
+	leaderLastTerm int //leaderTerm内leader日志最后一条的term
+}
+
+// SetChecked 开启或关闭检查模式
+func (rf *Raft) SetChecked(on bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.checked = on
+	rf.chk = invariantState{commitIndex: rf.commitIndex, term: rf.CurrentTerm}
+}
+
+// Violation 返回检查模式下发现的第一个违反不变量的报告，没有时返回空串
+func (rf *Raft) Violation() string {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.violation
+}
+
+// check 在持有rf.mu、状态变化之后调用，where说明是哪里的变化
+func (rf *Raft) check(where string) {
+	if !rf.checked || rf.violation != "" {
+		return
+	}
+	last := len(rf.Log) - 1
+	switch {
+	case rf.commitIndex < rf.chk.commitIndex:
+		rf.violate(where, "commitIndex decreased from %d to %d", rf.chk.commitIndex, rf.commitIndex)
+	case rf.CurrentTerm < rf.chk.term:
+		rf.violate(where, "term decreased from %d to %d", rf.chk.term, rf.CurrentTerm)
+	case rf.lastApplied > rf.commitIndex:
+		rf.violate(where, "lastApplied %d > commitIndex %d", rf.lastApplied, rf.commitIndex)
+	case rf.commitIndex > last:
+		rf.violate(where, "commitIndex %d beyond last log index %d", rf.commitIndex, last)
+	case rf.state == Leader && rf.chk.leaderTerm == rf.CurrentTerm &&
+		(last+1 < rf.chk.leaderLen || rf.Log[rf.chk.leaderLen-1].Term != rf.chk.leaderLastTerm):
+		rf.violate(where, "leader truncated its own log in term %d: length %d (term %d at index %d) became %d",
+			rf.CurrentTerm, rf.chk.leaderLen, rf.chk.leaderLastTerm, rf.chk.leaderLen-1, last+1)
+	}
+	if rf.violation != "" {
+		return
+	}
+
+	rf.chk.commitIndex = rf.commitIndex
+	rf.chk.term = rf.CurrentTerm
+	rf.chk.leaderTerm = 0
+	if rf.state == Leader {
+		rf.chk.leaderTerm = rf.CurrentTerm
+		rf.chk.leaderLen = last + 1
+		rf.chk.leaderLastTerm = rf.Log[last].Term
+	}
+}
+
+// checkVote 投票返回之前检查持久化的状态里已经记下了这次投票
+func (rf *Raft) checkVote(args *RequestVoteArgs) {
+	if !rf.checked || rf.violation != "" {
+		return
+	}
+	var term, votedFor int
+	d := gob.NewDecoder(bytes.NewBuffer(rf.persister.ReadRaftState()))
+	if d.Decode(&term) != nil || d.Decode(&votedFor) != nil || term != args.Term || votedFor != args.CandidatId {
+		rf.violate("RequestVote", "vote for %d in term %d returned before it was persisted (persisted term %d votedFor %d)",
+			args.CandidatId, args.Term, term, votedFor)
+	}
+}
+
+// violate 记录报告，并让节点停止运行：server()循环退出，RPC处理和回复处理停在下一个检查点
+func (rf *Raft) violate(where string, format string, a ...interface{}) {
+	first := maxInt(0, len(rf.Log)-10)
+	stack := make([]byte, 8192)
+	stack = stack[:runtime.Stack(stack, false)]
+	rf.violation = fmt.Sprintf("raft %d: invariant violated in %s: %s\n"+
+		"state %d term %d votedFor %d leaderId %d commitIndex %d lastApplied %d log length %d members %v\n"+
+		"log from index %d: %+v\n%s",
+		rf.me, where, fmt.Sprintf(format, a...),
+		rf.state, rf.CurrentTerm, rf.VotedFor, rf.leaderId, rf.commitIndex, rf.lastApplied, len(rf.Log), rf.voters(),
+		first, rf.Log[first:], stack)
+	log.Print(rf.violation)
+
+	rf.done = true
+	if rf.resume == nil {
+		rf.resume = make(chan struct{})
+	}
+}
@@ -0,0 +1,73 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// 超过提交限制时Propose的错误
+const (
+	ErrTooLarge   = "entry too large" //单条命令超过MaxEntryBytes，重试也不会被接受
+	ErrOverloaded = "overloaded"      //leader上未提交的日志已达上限，等已有的日志提交后再重试
+)
+
+// Limits leader接受新命令的限制，为0的项不限制。
+// follower不可达时未提交的日志无法提交，限制它们的数量和大小可以避免leader的日志无限增长
+type Limits struct {
+	MaxEntryBytes         int `json:"maxEntryBytes"`         //单条命令的最大字节数
+	MaxUncommittedEntries int `json:"maxUncommittedEntries"` //未提交日志的最大条数
+	MaxUncommittedBytes   int `json:"maxUncommittedBytes"`   //未提交日志中命令的总字节数上限
+}
+
+// SetLimits 设置本节点作为leader时接受命令的限制
+func (rf *Raft) SetLimits(l Limits) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.limits = l
+}
+
+// commandSize 命令的大小：Payload、string和[]byte取其长度，其它类型取gob编码后的长度。
+// gob无法编码的命令返回错误
+func commandSize(command interface{}) (int, error) {
+	switch c := command.(type) {
+	case Payload:
+		return len(c), nil
+	case string:
+		return len(c), nil
+	case []byte:
+		return len(c), nil
+	}
+	w := new(bytes.Buffer)
+	if err := gob.NewEncoder(w).Encode(&command); err != nil {
+		return 0, err
+	}
+	return w.Len(), nil
+}
+
+// checkLimits 检查leader能否再追加一条命令，可以时返回空串
+func (rf *Raft) checkLimits(entry LogEntry) string {
+	l := rf.limits
+	if l.MaxEntryBytes == 0 && l.MaxUncommittedEntries == 0 && l.MaxUncommittedBytes == 0 {
+		return ""
+	}
+	size, err := commandSize(entry.Command)
+	//无法编码的命令大小未知，按超过限制拒绝，而不是跳过检查
+	if err != nil || (l.MaxEntryBytes > 0 && size > l.MaxEntryBytes) {
+		return ErrTooLarge
+	}
+	uncommitted := rf.Log[rf.commitIndex+1:]
+	if l.MaxUncommittedEntries > 0 && len(uncommitted)+1 > l.MaxUncommittedEntries {
+		return ErrOverloaded
+	}
+	if l.MaxUncommittedBytes > 0 {
+		for _, e := range uncommitted {
+			n, _ := commandSize(e.Command) //只限制新的命令，日志中已有的命令无法编码时按0计
+			size += n
+		}
+		//一条命令本身就超过上限时，等日志全部提交后仍然允许追加，否则它永远无法被接受
+		if size > l.MaxUncommittedBytes && len(uncommitted) > 0 {
+			return ErrOverloaded
+		}
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package raft
+
+import (
+	"log"
+	"sort"
+)
+
+// voters 参与选举和日志复制的节点。Members为空时是peers中的所有节点
+func (rf *Raft) voters() []int {
+	if len(rf.Members) > 0 {
+		return rf.Members
+	}
+	all := make([]int, len(rf.peers))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// isVoter server是否属于当前的集群
+func (rf *Raft) isVoter(server int) bool {
+	for _, i := range rf.voters() {
+		if i == server {
+			return true
+		}
+	}
+	return false
+}
+
+// quorum 当前集群的多数派大小
+func (rf *Raft) quorum() int {
+	return len(rf.voters())/2 + 1
+}
+
+// GetMembers 返回当前集群的成员
+func (rf *Raft) GetMembers() []int {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return append([]int(nil), rf.voters()...)
+}
+
+// ForceNewCluster 灾难恢复用的不安全操作：多数派永久丢失时，把本节点的配置改写为只包含members的新集群，
+// 保留本节点的日志，之后的选举和提交只需要members中的多数派。
+// 这会破坏raft的安全性：只在丢失的节点上提交过的日志会永久丢失，本节点上未提交的日志可能被提交，
+// 被移出的节点仍然保留旧的配置，它们恢复后可能在旧配置的多数派中选出自己的leader并提交日志，
+// 所以调用方必须保证被移出的节点已经停止或断开，并且不再让它们回到网络中。每个幸存节点都要以相同的members调用一次，members必须包含本节点
+func (rf *Raft) ForceNewCluster(members []int) bool {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	members = append([]int(nil), members...)
+	sort.Ints(members)
+	self := false
+	for j, i := range members {
+		if i < 0 || i >= len(rf.peers) || (j > 0 && members[j-1] == i) {
+			return false
+		}
+		self = self || i == rf.me
+	}
+	if !self {
+		return false
+	}
+	log.Printf("raft %d: UNSAFE force new cluster %v (was %v) at term %d: "+
+		"log length %d, commitIndex %d; entries committed only on removed nodes are lost, "+
+		"uncommitted entries here may become committed",
+		rf.me, members, rf.voters(), rf.CurrentTerm, len(rf.Log), rf.commitIndex)
+
+	rf.Members = members
+	rf.persist()
+	return true
+}
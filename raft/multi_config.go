@@ -0,0 +1,260 @@
+package raft
+
+//
+// support for testing (and displaying) several Raft groups that
+// share one simulated network. every server hosts one replica of
+// each group, all multiplexed over a single labrpc.Server.
+//
+
+import (
+	"fmt"
+	"hadoop-raft/labrpc"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type multiConfig struct {
+	mu        sync.Mutex
+	t         *testing.T
+	net       *labrpc.Network
+	clock     labrpc.Clock // shared by the network and every MultiRaft
+	n         int          // servers
+	ngroups   int
+	done      int32
+	servers   []*MultiRaft
+	applyErr  []string // per group
+	connected []bool
+	endnames  [][]string
+	logs      [][]map[int]interface{} // [group][server] committed entries
+}
+
+func make_multi_config(t *testing.T, n int, ngroups int, unreliable bool) *multiConfig {
+	cfg := &multiConfig{}
+	cfg.t = t
+	cfg.net = labrpc.MakeNetwork()
+	cfg.clock = labrpc.MakeRealClock()
+	cfg.net.SetClock(cfg.clock)
+	cfg.n = n
+	cfg.ngroups = ngroups
+	cfg.servers = make([]*MultiRaft, n)
+	cfg.applyErr = make([]string, ngroups)
+	cfg.connected = make([]bool, n)
+	cfg.endnames = make([][]string, n)
+	cfg.logs = make([][]map[int]interface{}, ngroups)
+	for g := 0; g < ngroups; g++ {
+		cfg.logs[g] = make([]map[int]interface{}, n)
+		for i := 0; i < n; i++ {
+			cfg.logs[g][i] = map[int]interface{}{}
+		}
+	}
+
+	cfg.net.Reliable(!unreliable)
+	cfg.net.LongDelays(true)
+
+	for i := 0; i < n; i++ {
+		cfg.start1(i)
+	}
+	for i := 0; i < n; i++ {
+		cfg.connect(i)
+	}
+	return cfg
+}
+
+// start server i with one replica of every group.
+func (cfg *multiConfig) start1(i int) {
+	cfg.endnames[i] = make([]string, cfg.n)
+	ends := make([]*labrpc.ClientEnd, cfg.n)
+	for j := 0; j < cfg.n; j++ {
+		cfg.endnames[i][j] = randstring(20)
+		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
+		cfg.net.Connect(cfg.endnames[i][j], j)
+	}
+
+	mr := MakeMultiRaft(ends, i, cfg.clock)
+	for g := 0; g < cfg.ngroups; g++ {
+		applyCh := make(chan ApplyMsg)
+		go cfg.applier(g, i, applyCh)
+		mr.AddGroup(g, MakePersister(), applyCh)
+	}
+
+	cfg.mu.Lock()
+	cfg.servers[i] = mr
+	cfg.mu.Unlock()
+
+	svc := labrpc.MakeNamedService("Raft", mr)
+	srv := labrpc.MakeServer()
+	srv.AddService(svc)
+	cfg.net.AddServer(i, srv)
+}
+
+// check that the entries applied by replica i of group g agree
+// with every other replica of that group.
+func (cfg *multiConfig) applier(g int, i int, applyCh chan ApplyMsg) {
+	for m := range applyCh {
+		if m.UseSnapshot {
+			continue
+		}
+		err_msg := ""
+		cfg.mu.Lock()
+		for j := 0; j < cfg.n; j++ {
+			if old, oldok := cfg.logs[g][j][m.Index]; oldok && !sameCommand(old, m.Command) {
+				err_msg = fmt.Sprintf("group %v commit index=%v server=%v %v != server=%v %v",
+					g, m.Index, i, m.Command, j, old)
+			}
+		}
+		cfg.logs[g][i][m.Index] = m.Command
+		if err_msg != "" {
+			cfg.applyErr[g] = err_msg
+		}
+		cfg.mu.Unlock()
+
+		if err_msg != "" {
+			if cfg.t != nil {
+				log.Fatalf("apply error: %v\n", err_msg)
+			}
+			log.Printf("apply error: %v\n", err_msg)
+		}
+	}
+}
+
+func (cfg *multiConfig) cleanup() {
+	for i := 0; i < cfg.n; i++ {
+		if cfg.servers[i] != nil {
+			cfg.servers[i].Kill()
+		}
+	}
+	atomic.StoreInt32(&cfg.done, 1)
+}
+
+// attach server i to the net.
+func (cfg *multiConfig) connect(i int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.connected[i] = true
+	for j := 0; j < cfg.n; j++ {
+		if cfg.connected[j] {
+			cfg.net.Enable(cfg.endnames[i][j], true)
+			cfg.net.Enable(cfg.endnames[j][i], true)
+		}
+	}
+}
+
+// detach server i from the net.
+func (cfg *multiConfig) disconnect(i int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.connected[i] = false
+	for j := 0; j < cfg.n; j++ {
+		cfg.net.Enable(cfg.endnames[i][j], false)
+		cfg.net.Enable(cfg.endnames[j][i], false)
+	}
+}
+
+func (cfg *multiConfig) isConnected(i int) bool {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.connected[i]
+}
+
+// the connected leader of group g with the highest term, or -1.
+func (cfg *multiConfig) leader(g int) int {
+	leader, leaderTerm := -1, -1
+	for i := 0; i < cfg.n; i++ {
+		if !cfg.isConnected(i) {
+			continue
+		}
+		if term, isLeader := cfg.servers[i].Group(g).GetState(); isLeader && term > leaderTerm {
+			leader, leaderTerm = i, term
+		}
+	}
+	return leader
+}
+
+// check that every group has exactly one leader per term.
+// returns the leader of each group.
+func (cfg *multiConfig) checkLeaders() []int {
+	leaders := make([]int, cfg.ngroups)
+	for g := 0; g < cfg.ngroups; g++ {
+		leaders[g] = -1
+	}
+	for iters := 0; iters < 10; iters++ {
+		time.Sleep(500 * time.Millisecond)
+		missing := false
+		for g := 0; g < cfg.ngroups; g++ {
+			terms := map[int]int{}
+			for i := 0; i < cfg.n; i++ {
+				if !cfg.isConnected(i) {
+					continue
+				}
+				if term, isLeader := cfg.servers[i].Group(g).GetState(); isLeader {
+					terms[term]++
+					if terms[term] > 1 {
+						cfg.t.Fatalf("group %d term %d has more than one leader", g, term)
+					}
+				}
+			}
+			leaders[g] = cfg.leader(g)
+			if leaders[g] == -1 {
+				missing = true
+			}
+		}
+		if !missing {
+			return leaders
+		}
+	}
+	cfg.t.Fatalf("expected one leader in every group, got %v", leaders)
+	return nil
+}
+
+// how many replicas of group g have committed index?
+func (cfg *multiConfig) nCommitted(g int, index int) (int, interface{}) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.applyErr[g] != "" {
+		cfg.t.Fatal(cfg.applyErr[g])
+	}
+	count := 0
+	var cmd interface{} = -1
+	for i := 0; i < cfg.n; i++ {
+		if cmd1, ok := cfg.logs[g][i][index]; ok {
+			count++
+			cmd = cmd1
+		}
+	}
+	return count, cmd
+}
+
+// submit cmd to group g and wait until at least expectedServers
+// replicas have committed it. returns the index.
+func (cfg *multiConfig) one(g int, cmd interface{}, expectedServers int) int {
+	t0 := time.Now()
+	for time.Since(t0).Seconds() < 10 {
+		index := -1
+		for i := 0; i < cfg.n; i++ {
+			if !cfg.isConnected(i) {
+				continue
+			}
+			if index1, _, ok := cfg.servers[i].Group(g).Start(cmd); ok {
+				index = index1
+				break
+			}
+		}
+		if index != -1 {
+			t1 := time.Now()
+			for time.Since(t1).Seconds() < 2 {
+				nd, cmd1 := cfg.nCommitted(g, index)
+				if nd >= expectedServers && sameCommand(cmd1, cmd) {
+					return index
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		} else {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	cfg.t.Fatalf("group %d one(%v) failed to reach agreement", g, cmd)
+	return -1
+}
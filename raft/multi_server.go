@@ -0,0 +1,109 @@
+package raft
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var multiCfg *multiConfig
+
+// MultiStartNodes 初始化multi-raft网络，servers个节点，每个节点上承载groups个group
+func MultiStartNodes(c *gin.Context) {
+	if multiCfg != nil {
+		c.JSON(200, gin.H{
+			"msg": "already started",
+		})
+		return
+	}
+	servers, _ := strconv.Atoi(c.Query("servers"))
+	groups, _ := strconv.Atoi(c.Query("groups"))
+	multiCfg = make_multi_config(nil, servers, groups, false)
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// MultiCleanNodes 删除multi-raft的所有节点
+func MultiCleanNodes(c *gin.Context) {
+	if multiCfg != nil {
+		multiCfg.cleanup()
+	}
+	multiCfg = nil
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// MultiDisconnectNode 断开编号为number的节点，该节点上的所有group副本一起断开
+func MultiDisconnectNode(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	multiCfg.disconnect(number)
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// MultiReconnectNode 重连编号为number的节点
+func MultiReconnectNode(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	multiCfg.connect(number)
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// MultiGetState 获取所有节点上所有group的状态，groups[g][i]为节点i上group g的副本
+func MultiGetState(c *gin.Context) {
+	groups := make([][]Status, multiCfg.ngroups)
+	for g := range groups {
+		groups[g] = make([]Status, multiCfg.n)
+		for i := 0; i < multiCfg.n; i++ {
+			groups[g][i] = multiCfg.servers[i].Group(g).Status()
+		}
+	}
+	connected := make([]bool, multiCfg.n)
+	stats := make([]MultiRaftStats, multiCfg.n)
+	for i := 0; i < multiCfg.n; i++ {
+		connected[i] = multiCfg.isConnected(i)
+		stats[i] = multiCfg.servers[i].Stats()
+	}
+	multiCfg.mu.Lock()
+	applyErr := append([]string{}, multiCfg.applyErr...)
+	multiCfg.mu.Unlock()
+
+	c.JSON(200, gin.H{
+		"groups":    groups,
+		"connected": connected,
+		"stats":     stats,
+		"applyErr":  applyErr,
+	})
+}
+
+// MultiStartCommand 向group的leader提交command
+func MultiStartCommand(c *gin.Context) {
+	group, _ := strconv.Atoi(c.Query("group"))
+	cmd := Payload(c.Query("command"))
+	leader := multiCfg.leader(group)
+	if leader == -1 {
+		c.JSON(200, gin.H{
+			"index":    -1,
+			"term":     -1,
+			"isLeader": false,
+			"leader":   -1,
+		})
+		return
+	}
+	index, term, isLeader := multiCfg.servers[leader].Group(group).Start(cmd)
+	c.JSON(200, gin.H{
+		"index":    index,
+		"term":     term,
+		"isLeader": isLeader,
+		"leader":   leader,
+	})
+}
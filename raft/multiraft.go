@@ -0,0 +1,206 @@
+package raft
+
+import (
+	"hadoop-raft/labrpc"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 同一时间窗口内发往同一节点的心跳会被合并成一次RPC。
+// 窗口取心跳间隔(50ms)的一半，几轮之后同一leader上各group的心跳都会落进同一个窗口
+const heartbeatBatchWindow = 25 * time.Millisecond
+
+// MultiRaft 在一个labrpc.Server上承载多个相互独立的raft group。
+// 所有group共用同一组ClientEnd，并以"Raft"的服务名注册，
+// AppendEntries/RequestVote按照请求中的GroupId路由到对应的group
+type MultiRaft struct {
+	mu       sync.Mutex
+	me       int
+	peers    []*labrpc.ClientEnd
+	clock    labrpc.Clock //所有group共用
+	groups   map[int]*Raft
+	batchers []*heartbeatBatcher //每个目标节点一个
+
+	heartbeats int64 //各group发出的心跳总数
+	batches    int64 //合并后实际发出的心跳RPC数
+}
+
+// HeartbeatsArgs 合并后的心跳，每个group一条
+type HeartbeatsArgs struct {
+	Heartbeats []AppendEntriesArgs
+}
+
+// HeartbeatsReply 与HeartbeatsArgs.Heartbeats一一对应的回复
+type HeartbeatsReply struct {
+	Replies []AppendEntriesReply
+}
+
+// MultiRaftStats 心跳合并的统计
+type MultiRaftStats struct {
+	Groups     int   `json:"groups"`
+	Heartbeats int64 `json:"heartbeats"`
+	Batches    int64 `json:"batches"`
+}
+
+// MakeMultiRaft 创建编号为me的节点，peers是到各个节点的ClientEnd，由所有group共用，
+// 各group的计时和随机数以及心跳合并的窗口都取自clock（见MakeWithClock）
+func MakeMultiRaft(peers []*labrpc.ClientEnd, me int, clock labrpc.Clock) *MultiRaft {
+	mr := &MultiRaft{}
+	mr.me = me
+	mr.peers = peers
+	mr.clock = clock
+	mr.groups = map[int]*Raft{}
+	mr.batchers = make([]*heartbeatBatcher, len(peers))
+	for i := range peers {
+		mr.batchers[i] = &heartbeatBatcher{mr: mr, server: i}
+	}
+	return mr
+}
+
+// AddGroup 在该节点上启动group gid的一个raft副本
+func (mr *MultiRaft) AddGroup(gid int, persister *Persister, applyCh chan ApplyMsg) *Raft {
+	rf := newRaft(mr.peers, mr.me, persister, applyCh, mr.clock)
+	rf.gid = gid
+	rf.heartbeats = mr.sendHeartbeat
+
+	mr.mu.Lock()
+	mr.groups[gid] = rf
+	mr.mu.Unlock()
+
+	go rf.server()
+	return rf
+}
+
+// Group 返回group gid在该节点上的副本，不存在时返回nil
+func (mr *MultiRaft) Group(gid int) *Raft {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	return mr.groups[gid]
+}
+
+// Groups 返回该节点承载的所有group id（升序）
+func (mr *MultiRaft) Groups() []int {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	gids := make([]int, 0, len(mr.groups))
+	for gid := range mr.groups {
+		gids = append(gids, gid)
+	}
+	sort.Ints(gids)
+	return gids
+}
+
+// Stats 返回心跳合并的统计
+func (mr *MultiRaft) Stats() MultiRaftStats {
+	mr.mu.Lock()
+	groups := len(mr.groups)
+	mr.mu.Unlock()
+	return MultiRaftStats{
+		Groups:     groups,
+		Heartbeats: atomic.LoadInt64(&mr.heartbeats),
+		Batches:    atomic.LoadInt64(&mr.batches),
+	}
+}
+
+// Kill 关闭该节点上的所有group
+func (mr *MultiRaft) Kill() {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	for _, rf := range mr.groups {
+		rf.Kill()
+	}
+}
+
+// AppendEntries 把请求交给对应的group处理。
+// 不存在的group返回空的回复，leader会把它当作一次普通的失败
+func (mr *MultiRaft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) {
+	if rf := mr.Group(args.GroupId); rf != nil {
+		rf.AppendEntries(args, reply)
+	}
+}
+
+// RequestVote 把投票请求交给对应的group处理
+func (mr *MultiRaft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
+	if rf := mr.Group(args.GroupId); rf != nil {
+		rf.RequestVote(args, reply)
+	}
+}
+
+// Forward 把follower转发来的命令交给对应的group
+func (mr *MultiRaft) Forward(args *ForwardArgs, reply *ForwardReply) {
+	if rf := mr.Group(args.GroupId); rf != nil {
+		rf.Forward(args, reply)
+	} else {
+		reply.LeaderId = NoLeader
+	}
+}
+
+// Heartbeats 逐个处理合并后的心跳
+func (mr *MultiRaft) Heartbeats(args *HeartbeatsArgs, reply *HeartbeatsReply) {
+	reply.Replies = make([]AppendEntriesReply, len(args.Heartbeats))
+	for i := range args.Heartbeats {
+		mr.AppendEntries(&args.Heartbeats[i], &reply.Replies[i])
+	}
+}
+
+// sendHeartbeat 作为各group的心跳发送函数，阻塞到合并后的RPC返回
+func (mr *MultiRaft) sendHeartbeat(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool {
+	atomic.AddInt64(&mr.heartbeats, 1)
+	return mr.batchers[server].send(args, reply)
+}
+
+// 等待合并发送的一条心跳
+type pendingHeartbeat struct {
+	args  *AppendEntriesArgs
+	reply *AppendEntriesReply
+	done  chan bool
+}
+
+// heartbeatBatcher 收集发往同一节点的心跳，窗口结束时一起发送
+type heartbeatBatcher struct {
+	mu      sync.Mutex
+	mr      *MultiRaft
+	server  int
+	pending []pendingHeartbeat
+}
+
+func (b *heartbeatBatcher) send(args *AppendEntriesArgs, reply *AppendEntriesReply) bool {
+	done := make(chan bool, 1)
+	b.mu.Lock()
+	b.pending = append(b.pending, pendingHeartbeat{args, reply, done})
+	if len(b.pending) == 1 {
+		//窗口内的第一条心跳负责安排发送
+		go func() {
+			<-b.mr.clock.After(heartbeatBatchWindow)
+			b.flush()
+		}()
+	}
+	b.mu.Unlock()
+	return <-done
+}
+
+func (b *heartbeatBatcher) flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	args := HeartbeatsArgs{Heartbeats: make([]AppendEntriesArgs, len(pending))}
+	for i, p := range pending {
+		args.Heartbeats[i] = *p.args
+	}
+	reply := HeartbeatsReply{}
+	atomic.AddInt64(&b.mr.batches, 1)
+	ok := b.mr.peers[b.server].Call("Raft.Heartbeats", &args, &reply)
+	if ok && len(reply.Replies) != len(pending) {
+		ok = false
+	}
+	for i, p := range pending {
+		if ok {
+			*p.reply = reply.Replies[i]
+		}
+		p.done <- ok
+	}
+}
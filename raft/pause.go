@@ -0,0 +1,45 @@
+package raft
+
+// Pause 冻结节点，模拟GC停顿或虚拟机卡顿：server()循环、RPC处理、日志apply以及在途RPC的回复处理
+// 都会在下一个检查点停下，网络连接保持不变，其它节点发来的RPC会一直等待，直到Resume。
+// 内存中的状态保持不变，恢复后节点继续按停顿前的状态运行（例如仍然以为自己是leader）
+func (rf *Raft) Pause() {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.resume == nil {
+		rf.resume = make(chan struct{})
+	}
+}
+
+// Resume 恢复被暂停的节点。检查模式下因违反不变量而停止的节点不能恢复
+func (rf *Raft) Resume() {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.violation == "" {
+		rf.unfreeze()
+	}
+}
+
+func (rf *Raft) unfreeze() {
+	if rf.resume != nil {
+		close(rf.resume)
+		rf.resume = nil
+	}
+}
+
+// Paused 节点是否处于暂停状态
+func (rf *Raft) Paused() bool {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.resume != nil
+}
+
+// waitIfPaused 节点被暂停时阻塞到恢复为止，调用方不能持有rf.mu
+func (rf *Raft) waitIfPaused() {
+	rf.mu.Lock()
+	resume := rf.resume
+	rf.mu.Unlock()
+	if resume != nil {
+		<-resume
+	}
+}
@@ -0,0 +1,22 @@
+package raft
+
+import (
+	"encoding/json"
+	"hadoop-raft/labrpc"
+)
+
+// Payload 不透明的命令内容，raft只负责复制，不关心其中的格式。
+// 用string保存原始字节，所以Payload是可比较的，apply端可以直接用==检查各节点是否一致
+type Payload string
+
+func init() {
+	labrpc.Register(Payload(""))
+}
+
+// MarshalJSON 展示时，合法的JSON按原样输出，其它内容输出为字符串
+func (p Payload) MarshalJSON() ([]byte, error) {
+	if json.Valid([]byte(p)) {
+		return []byte(p), nil
+	}
+	return json.Marshal(string(p))
+}
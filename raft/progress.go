@@ -0,0 +1,96 @@
+package raft
+
+//
+// leader为每个follower维护复制进度的状态，不同的状态有不同的发送方式：
+//   probe：还不知道follower的日志和自己匹配到哪里（刚成为leader、被拒绝或者RPC失败之后）。
+//     每个心跳周期只从nextIndex发送一次，根据回复中的冲突信息回退nextIndex，直到找到匹配的位置；
+//   replicate：已经找到匹配的位置，日志稳定复制。新日志只发送一次，发送后nextIndex立即前进，
+//     下一个周期只发送更新的日志，不再重发还在途中的日志。
+// 被拒绝或RPC失败时回到probe，从matchIndex之后重新发送。
+// 没有日志压缩和InstallSnapshot，所以没有发送snapshot的状态
+//
+
+// follower的复制进度状态
+const (
+	ProgressProbe     = "probe"
+	ProgressReplicate = "replicate"
+)
+
+// Progress leader上一个follower的复制进度，供展示follower为什么落后
+type Progress struct {
+	Server int    `json:"server"`
+	State  string `json:"state"`
+	Match  int    `json:"match"` //已知与leader一致的最后一条日志
+	Next   int    `json:"next"`  //下一次发送的第一条日志
+}
+
+// Progress 返回leader上每个follower的复制进度，不是leader时返回nil
+func (rf *Raft) Progress() []Progress {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.progressLocked()
+}
+
+func (rf *Raft) progressLocked() []Progress {
+	if rf.state != Leader {
+		return nil
+	}
+	var prs []Progress
+	for _, i := range rf.voters() {
+		if i == rf.me {
+			continue
+		}
+		prs = append(prs, Progress{
+			Server: i,
+			State:  rf.progress[i],
+			Match:  rf.matchIndex[i],
+			Next:   rf.nextIndex[i],
+		})
+	}
+	return prs
+}
+
+// appendRequest 按照follower i的复制进度生成本周期要发送的AppendEntries
+func (rf *Raft) appendRequest(i int, sent int64, commitTime int64) AppendEntriesRequest {
+	last := len(rf.Log) - 1
+	prev := rf.nextIndex[i] - 1
+	if rf.progress[i] == ProgressReplicate && rf.nextIndex[i] > last {
+		//没有新日志时只发心跳，确认已知匹配的位置，避免在途的日志还没到达时心跳被拒绝
+		prev = rf.matchIndex[i]
+	}
+	request := AppendEntriesRequest{
+		Follower:     i,
+		Term:         rf.CurrentTerm,
+		LeaderId:     rf.me,
+		PreLogIndex:  prev,
+		PreLogTerm:   rf.Log[prev].Term,
+		LeaderCommit: rf.commitIndex,
+		CommitTime:   commitTime,
+		Sent:         sent,
+	}
+	if rf.nextIndex[i] <= last {
+		//拷贝一份：请求在锁外编码，期间leader可能已经下台并截断、覆盖了自己的日志
+		request.Entries = append([]LogEntry(nil), rf.Log[rf.nextIndex[i]:]...)
+		if rf.progress[i] == ProgressReplicate {
+			//乐观地认为日志会到达，下一个周期只发送之后的日志
+			rf.nextIndex[i] = last + 1
+		}
+	}
+	return request
+}
+
+// becomeProbe follower回到probe状态，从next开始重新寻找匹配的位置
+func (rf *Raft) becomeProbe(follower int, next int) {
+	rf.progress[follower] = ProgressProbe
+	rf.nextIndex[follower] = next
+}
+
+// appendUnreachable 请求没有得到回复：复制中的日志可能丢失了，回到probe从matchIndex之后重发
+func (rf *Raft) appendUnreachable(req AppendEntriesRequest) {
+	if rf.state != Leader || rf.CurrentTerm != req.Term {
+		return
+	}
+	if rf.progress[req.Follower] == ProgressReplicate {
+		rf.becomeProbe(req.Follower, rf.matchIndex[req.Follower]+1)
+	}
+}
@@ -23,7 +23,7 @@ import (
 	"fmt"
 	"hadoop-raft/labrpc"
 	"math"
-	"math/rand"
+	"sort"
 	"sync"
 	"time"
 )
@@ -41,11 +41,17 @@ type ApplyMsg struct {
 	Command     interface{}
 	UseSnapshot bool   // ignore for lab2; only used in lab3
 	Snapshot    []byte // ignore for lab2; only used in lab3
+	Duplicate   bool   // a client retry that was already applied; the state machine should skip it
+	DuplicateOf int    // index at which the command was first applied, -1 if unknown
 }
 
 type LogEntry struct {
-	Command interface{} //client发送的执行命令
-	Term    int         //从leader读取到的term
+	Command   interface{} //client发送的执行命令
+	Term      int         //从leader读取到的term
+	ClientId  int64       //提交命令的client id，NoClient表示不做去重
+	Seq       int64       //client为命令分配的序号，同一client内单调递增
+	Timestamp int64       //leader收到命令时的时间(UnixNano)，用于会话过期判断
+	Proposer  int         //最初收到命令的节点，转发的命令是转发它的follower
 }
 
 const (
@@ -71,18 +77,25 @@ type Raft struct {
 	// Look at the paper's Figure 2 for a description of what
 	// state a Raft server must maintain.
 
-	state             int           // 所属的状态
-	heartbeatNotify   chan bool     //心跳通知
-	voteNotify        chan bool     //投票通知
-	electLeaderNotify chan bool     //选举leader通知
-	electionTimeout   time.Duration //选举超时channel
-	votedCount        int           //票数
-	leaderId          int           //领导者id
+	state             int            // 所属的状态
+	heartbeatNotify   chan bool      //心跳通知
+	voteNotify        chan bool      //投票通知
+	electLeaderNotify chan bool      //选举leader通知
+	electionTimeout   time.Duration  //选举超时channel
+	votedCount        int            //票数
+	leaderId          int            //领导者id
+	forwarding        bool           //是否把follower上的提交转发给leader
+	resume            chan struct{}  //节点被暂停时非nil，恢复时关闭
+	clock             labrpc.Clock   //超时、心跳间隔以及随机数的来源，模拟时钟下只在推进时才走
+	checked           bool           //检查模式，见invariants.go
+	chk               invariantState //上一次检查时的状态
+	violation         string         //检查模式下发现的违反不变量的报告
 
 	//持久化数据
 	CurrentTerm int        // 最新term
 	VotedFor    int        // 保存的候选人id
 	Log         []LogEntry //日志
+	Members     []int      //ForceNewCluster改写后的集群成员，为空时是全部peers
 
 	//用户提交的channel
 	applyCh chan ApplyMsg //提交的日志，该channel是client传递给raft的一个参数，用于监听提交的消息
@@ -96,9 +109,26 @@ type Raft struct {
 	commitIndex int // 最新的已提交日志的index  单调递增
 	lastApplied int // 最新的已apply日志的index 单调递增
 
+	//client会话表，随apply更新，用于识别重复提交的命令
+	sessions *SessionTable
+
+	//每条日志在本节点上的提交和apply时间，下标与Log一致，只记录到已提交的日志
+	times []EntryTimes
+
+	//multi-raft下该节点所属的group，以及合并发送心跳的函数（单group时为nil，直接发送）
+	gid        int
+	heartbeats func(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool
+
 	//leader上的volatile数据，用数组存储用来维护每个server的index信息
-	nextIndex  []int // 即将要发送给所有server的日志
-	matchIndex []int // 已发送给所有server的日志的最高index
+	limits     Limits   // 接受新命令的限制，见limits.go
+	progress   []string // 每个server的复制进度状态，见progress.go
+	nextIndex  []int    // 即将要发送给所有server的日志
+	matchIndex []int    // 已发送给所有server的日志的最高index
+	ackTime    []int64  // 每个server最近一次确认本term的AppendEntries的发送时间(UnixNano)
+
+	//follower从leader得到的读证明，见staleread.go
+	readIndex int   // readTime之前提交的日志都不超过该index
+	readTime  int64 // leader最近一次被多数派确认的时间(UnixNano)，0表示没有证明
 }
 
 func (rf *Raft) isDone() bool {
@@ -120,6 +150,45 @@ func (rf *Raft) GetState() (int, bool) {
 	return term, isleader
 }
 
+// GetLeader 返回该节点所知道的leader，不知道时返回NoLeader
+func (rf *Raft) GetLeader() int {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.state == Leader {
+		return rf.me
+	}
+	return rf.leaderId
+}
+
+// Status 节点状态的快照，供raft包之外的展示使用
+type Status struct {
+	Number      int `json:"number"`
+	Term        int `json:"term"`
+	VotedFor    int `json:"votedFor"`
+	State       int `json:"state"`
+	VotedCount  int `json:"votedCount"`
+	LeaderId    int `json:"leaderId"`
+	LogLength   int `json:"logLength"`
+	CommitIndex int `json:"commitIndex"`
+	LastApplied int `json:"lastApplied"`
+}
+
+func (rf *Raft) Status() Status {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return Status{
+		Number:      rf.me,
+		Term:        rf.CurrentTerm,
+		VotedFor:    rf.VotedFor,
+		State:       rf.state,
+		VotedCount:  rf.votedCount,
+		LeaderId:    rf.leaderId,
+		LogLength:   len(rf.Log),
+		CommitIndex: rf.commitIndex,
+		LastApplied: rf.lastApplied,
+	}
+}
+
 func (rf *Raft) SyncState() int {
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
@@ -159,6 +228,7 @@ func (rf *Raft) persist() {
 	e.Encode(rf.CurrentTerm)
 	e.Encode(rf.VotedFor)
 	e.Encode(rf.Log)
+	e.Encode(rf.Members)
 	data := w.Bytes()
 	rf.persister.SaveRaftState(data)
 }
@@ -181,6 +251,7 @@ func (rf *Raft) readPersist(data []byte) {
 	d.Decode(&rf.CurrentTerm)
 	d.Decode(&rf.VotedFor)
 	d.Decode(&rf.Log)
+	d.Decode(&rf.Members)
 }
 
 //
@@ -189,6 +260,7 @@ func (rf *Raft) readPersist(data []byte) {
 //
 type RequestVoteArgs struct {
 	// Your data here (2A, 2B).
+	GroupId      int // raft group id，multi-raft下用于把请求路由到对应的group
 	Term         int // 候选人的term
 	CandidatId   int // 请求选票的候选人id
 	LastLogIndex int // 候选人最后一条日志的index
@@ -206,12 +278,14 @@ type RequestVoteReply struct {
 }
 
 type AppendEntriesArgs struct {
+	GroupId      int
 	Term         int
 	LeaderId     int
 	PreLogIndex  int
 	PreLogTerm   int
 	Entries      []LogEntry
 	LeaderCommit int
+	CommitTime   int64 // leader被多数派确认的最晚时间，在此之前提交的日志都不超过LeaderCommit，0表示无法证明
 }
 
 type AppendEntriesReply struct {
@@ -239,6 +313,8 @@ type AppendEntriesRequest struct {
 	PreLogTerm   int
 	Entries      []LogEntry
 	LeaderCommit int
+	CommitTime   int64
+	Sent         int64 // 发送时间，回复到达时记为该follower确认leader地位的时间
 }
 
 func (rf *Raft) canVote(candidateId int, candidateLastLogIndex int, candidateLastLogTerm int) bool {
@@ -263,13 +339,21 @@ func (rf *Raft) agreeVote(candidateId int) bool {
 
 func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
 	// Your code here (2A, 2B).
+	rf.waitIfPaused()
 	rf.mu.Lock()
 	defer func() {
 		rf.persist()
+		if reply.VoteGranted {
+			rf.checkVote(args)
+		}
+		rf.check("RequestVote")
 		rf.mu.Unlock()
+		//违反不变量的节点不再回复
+		rf.waitIfPaused()
 	}()
 
-	if args.Term < rf.CurrentTerm {
+	//ForceNewCluster之后，被移出集群的节点发来的请求一律拒绝
+	if args.Term < rf.CurrentTerm || !rf.isVoter(args.CandidatId) {
 		reply.Term = rf.CurrentTerm
 		reply.VoteGranted = false
 		return
@@ -295,13 +379,16 @@ func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
 
 //candidate或follower响应leader的AppendEntries请求
 func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) {
+	rf.waitIfPaused()
 	rf.mu.Lock()
 	defer func() {
 		rf.persist()
+		rf.check("AppendEntries")
 		rf.mu.Unlock()
+		rf.waitIfPaused()
 	}()
 
-	if args.Term < rf.CurrentTerm {
+	if args.Term < rf.CurrentTerm || !rf.isVoter(args.LeaderId) {
 		reply.Term = rf.CurrentTerm
 		reply.Success = false
 		return
@@ -319,29 +406,16 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 		rf.turnFollower(rf.CurrentTerm, args.LeaderId)
 	}
 
-	//receiver没有索引为PreLogIndex的日志
-	if args.PreLogIndex >= len(rf.Log) {
-		reply.Term = args.Term
-		reply.Success = false
-		//由于不含有PreLogIndex位置的日志，也就是还没有发现冲突日志，可以认为冲突index为日志长度，冲突term为nil（-1）
-		reply.ConflictIndex = len(rf.Log)
-		reply.ConflictTerm = -1
-		return
-	}
+	//同一term内的follower也要记住leader，用于重定向和转发
+	rf.leaderId = args.LeaderId
 
-	//receiver索引为PreLogIndex的日志与leader的不一致
-	if args.PreLogTerm != rf.Log[args.PreLogIndex].Term {
+	//receiver没有索引为PreLogIndex的日志，或者索引为PreLogIndex的日志与leader的不一致
+	if args.PreLogIndex >= len(rf.Log) || args.PreLogTerm != rf.Log[args.PreLogIndex].Term {
 		reply.Term = args.Term
 		reply.Success = false
 
 		//从receiver日志中定位冲突日志的term，并找到该term第一个日志的索引，即冲突索引的位置
-		reply.ConflictTerm = rf.Log[args.PreLogIndex].Term
-		for i := range rf.Log {
-			if rf.Log[i].Term == reply.ConflictTerm {
-				reply.ConflictIndex = i
-				break
-			}
-		}
+		reply.ConflictIndex, reply.ConflictTerm = rf.conflictHint(args.PreLogIndex)
 		return
 	}
 
@@ -356,29 +430,54 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 			}
 		}
 
-		rf.Log = rf.Log[:i+args.PreLogIndex+1]
+		//只有真正发现冲突时才截断，乱序到达的旧请求（日志全部匹配）不能截掉后面已追加的日志
+		if i < len(args.Entries) {
+			rf.Log = rf.Log[:i+args.PreLogIndex+1]
 
-		//从不匹配的位置开始，追加新日志
-		for _, item := range args.Entries[i:] {
-			rf.Log = append(rf.Log, item)
+			//从不匹配的位置开始，追加新日志
+			for _, item := range args.Entries[i:] {
+				rf.Log = append(rf.Log, item)
+			}
 		}
 	}
 
+	//commitIndex取leaderCommit与本次请求最后一条日志index的较小值，并且不回退
 	if args.LeaderCommit > rf.commitIndex {
-		rf.commitIndex = minInt(args.LeaderCommit, len(rf.Log)-1)
+		rf.commitTo(maxInt(rf.commitIndex, minInt(args.LeaderCommit, args.PreLogIndex+len(args.Entries))))
+	}
+
+	//记录leader带来的读证明，乱序到达的旧证明不能覆盖新的
+	if args.CommitTime > rf.readTime {
+		rf.readIndex = args.LeaderCommit
+		rf.readTime = args.CommitTime
 	}
 
 	reply.Term = args.Term
 	reply.Success = true
 }
 
-func (rf *Raft) apply(logs []LogEntry, start, end int) {
+// applyMsgs 按顺序生成[start, end]之间日志的ApplyMsg，同时更新会话表
+func (rf *Raft) applyMsgs(start, end int) []ApplyMsg {
+	msgs := make([]ApplyMsg, 0, end-start+1)
 	for i := start; i <= end; i++ {
-		debug("======>server %d role %s:commit log %+v at index %d", rf.me, rf.DisplayState(), logs[i].Command, i)
-		rf.applyCh <- ApplyMsg{
-			Index:   i,
-			Command: logs[i].Command,
-		}
+		dup, first := rf.sessions.Apply(i, rf.Log[i])
+		msgs = append(msgs, ApplyMsg{
+			Index:       i,
+			Command:     rf.Log[i].Command,
+			Duplicate:   dup,
+			DuplicateOf: first,
+		})
+	}
+	return msgs
+}
+
+func (rf *Raft) apply(msgs []ApplyMsg) {
+	for _, msg := range msgs {
+		debug("======>server %d role %s:commit log %+v at index %d", rf.me, rf.DisplayState(), msg.Command, msg.Index)
+		rf.applyCh <- msg
+		rf.mu.Lock()
+		rf.timesAt(msg.Index).Applied = rf.clock.Now().UnixNano()
+		rf.mu.Unlock()
 	}
 }
 
@@ -393,6 +492,17 @@ func minInt(a ...int) int {
 	return min
 }
 
+func maxInt(a ...int) int {
+	max := math.MinInt64
+	for _, i := range a {
+		if i > max {
+			max = i
+		}
+	}
+
+	return max
+}
+
 //
 // example code to send a RequestVote RPC to a server.
 // server is the index of the target server in rf.peers[].
@@ -423,7 +533,10 @@ func minInt(a ...int) int {
 // the struct itself.
 //
 func (rf *Raft) sendRequestVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool {
+	rf.waitIfPaused()
 	ok := rf.peers[server].Call("Raft.RequestVote", args, reply)
+	//暂停期间返回的回复要等恢复之后才处理
+	rf.waitIfPaused()
 	return ok
 }
 
@@ -438,14 +551,32 @@ func (rf *Raft) sendRequestVote(server int, args *RequestVoteArgs, reply *Reques
 // the first return value is the index that the command will appear at
 // if it's ever committed. the second return value is the current
 // term. the third return value is true if this server believes it is
-// the leader.
+// the leader. a leader that rejects the command because of the limits
+// set by SetLimits() returns index -1; Propose() says why.
 //
 func (rf *Raft) Start(command interface{}) (int, int, bool) {
+	index, term, isLeader, _ := rf.start(LogEntry{Command: command, ClientId: NoClient, Proposer: rf.me})
+	return index, term, isLeader
+}
+
+//
+// like Start(), but the command belongs to a client session. a command
+// whose seq is not above the client's last applied seq is delivered
+// with ApplyMsg.Duplicate set, so retries take effect only once.
+//
+func (rf *Raft) StartSession(clientId int64, seq int64, command interface{}) (int, int, bool) {
+	index, term, isLeader, _ := rf.start(LogEntry{Command: command, ClientId: clientId, Seq: seq, Proposer: rf.me})
+	return index, term, isLeader
+}
+
+// start leader追加一条日志。最后一个返回值是超过提交限制时的错误，此时index为-1
+func (rf *Raft) start(entry LogEntry) (int, int, bool, string) {
 	index := -1
 	term := -1
 	isLeader := true
 
 	// Your code here (2B).
+	rf.waitIfPaused()
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
 
@@ -455,17 +586,22 @@ func (rf *Raft) Start(command interface{}) (int, int, bool) {
 
 	//如果不是leader，不可发送appendEntries消息，提前返回false
 	if !isLeader {
-		return index, term, isLeader
+		return index, term, isLeader, ""
+	}
+
+	//超过限制时明确拒绝，而不是返回一个可能永远不会提交的index
+	if err := rf.checkLimits(entry); err != "" {
+		return -1, term, isLeader, err
 	}
 
-	rf.Log = append(rf.Log, LogEntry{
-		Command: command,
-		Term:    term,
-	})
+	entry.Term = term
+	entry.Timestamp = rf.clock.Now().UnixNano()
+	rf.Log = append(rf.Log, entry)
 
 	rf.persist()
+	rf.check("Start")
 
-	return index, term, isLeader
+	return index, term, isLeader, ""
 }
 
 func (rf *Raft) handleReply(
@@ -492,113 +628,103 @@ func (rf *Raft) decreaseNextIndexFunc() func(rf *Raft, req AppendEntriesRequest,
 		//rf.nextIndex[request.Follower]--
 
 		//2. 优化版本
-		//找到最后一条term=冲突term的日志
-		var n int
-		for n = 0; n < len(rf.Log); n++ {
-			if rf.Log[n].Term == resp.ConflictTerm {
-				break
-			}
+		//过期的响应（已不是发送请求时那个term的leader）直接忽略
+		if rf.state != Leader || rf.CurrentTerm != req.Term {
+			return
 		}
 
-		//没有找到冲突term的日志
-		if n == len(rf.Log) {
-			rf.nextIndex[req.Follower] = resp.ConflictIndex
-		} else {
-			for rf.Log[n].Term == resp.ConflictTerm {
-				n++
-			}
-			rf.nextIndex[req.Follower] = n
-		}
+		//根据冲突term定位nextIndex，见nextIndexForConflict，并回到probe状态寻找匹配的位置
+		rf.becomeProbe(req.Follower, rf.nextIndexForConflict(resp.ConflictIndex, resp.ConflictTerm))
+	}
+}
+
+// advanceCommitIndex leader根据各节点的matchIndex推进commitIndex：
+// 将matchIndex降序排列后，下标为len/2的值就是多数派都已复制到的最大index
+func (rf *Raft) advanceCommitIndex() {
+	rf.matchIndex[rf.me] = len(rf.Log) - 1
+
+	voters := rf.voters()
+	match := make([]int, len(voters))
+	for k, i := range voters {
+		match[k] = rf.matchIndex[i]
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(match)))
+	n := match[len(match)/2]
+
+	//leader只能通过统计副本数提交当前term的日志（论文5.4.2），并且commitIndex不回退
+	if n > rf.commitIndex && rf.Log[n].Term == rf.CurrentTerm {
+		rf.commitTo(n)
 	}
 }
 
 func (rf *Raft) turnFollowerFunc() func(rf *Raft, req AppendEntriesRequest, resp AppendEntriesReply) {
 	return func(rf *Raft, req AppendEntriesRequest, resp AppendEntriesReply) {
+		//过期请求的响应到达时自己的term可能已经更大，不能让term回退
+		if resp.Term <= rf.CurrentTerm {
+			return
+		}
 		rf.turnFollower(resp.Term, req.LeaderId)
 	}
 }
 
 func (rf *Raft) broadcastAppendEntries() {
 	rf.mu.Lock()
-	for i := range rf.peers {
+	now := rf.clock.Now().UnixNano()
+	commitTime := rf.commitTime(now)
+	for _, i := range rf.voters() {
 		if i == rf.me {
-			rf.matchIndex[i]++
-			rf.nextIndex[i]++
-		} else if rf.nextIndex[i] <= len(rf.Log)-1 {
-			request := AppendEntriesRequest{
-				Follower:     i,
-				Term:         rf.CurrentTerm,
-				LeaderId:     rf.me,
-				PreLogIndex:  rf.nextIndex[i] - 1,
-				PreLogTerm:   rf.Log[rf.nextIndex[i]-1].Term,
-				Entries:      rf.Log[rf.nextIndex[i]:],
-				LeaderCommit: rf.commitIndex,
-			}
-			go func(request AppendEntriesRequest) {
-				req := AppendEntriesArgs{
-					Term:         request.Term,
-					LeaderId:     request.LeaderId,
-					PreLogIndex:  request.PreLogIndex,
-					PreLogTerm:   request.PreLogTerm,
-					Entries:      request.Entries,
-					LeaderCommit: request.LeaderCommit,
-				}
-				resp := AppendEntriesReply{}
-				ok := rf.sendAppendEntries(request.Follower, &req, &resp)
-				rf.mu.Lock()
-				if ok {
-					rf.handleReply(request, resp, func(rf *Raft, req AppendEntriesRequest, resp AppendEntriesReply) {
-						rf.matchIndex[req.Follower] = req.PreLogIndex + len(req.Entries)
-						rf.nextIndex[req.Follower] = rf.matchIndex[req.Follower] + 1
-
-						for n := req.LeaderCommit + 1; n < len(rf.Log); n++ {
-
-							var replicas int
-
-							for m := range rf.peers {
-								if rf.matchIndex[m] >= n {
-									replicas++
-								}
-							}
-
-							if replicas > len(rf.peers)/2 && rf.Log[n].Term == req.Term {
-								rf.commitIndex = n
-							}
-						}
-					}, rf.turnFollowerFunc(), rf.decreaseNextIndexFunc())
+			//leader自身的日志总是全部匹配的
+			rf.matchIndex[i] = len(rf.Log) - 1
+			rf.nextIndex[i] = len(rf.Log)
+			//只剩自己一个成员时没有响应会触发提交
+			rf.advanceCommitIndex()
+			continue
+		}
 
-				}
-				rf.mu.Unlock()
-			}(request)
-		} else {
-			request := AppendEntriesRequest{
-				Follower:     i,
-				Term:         rf.CurrentTerm,
-				LeaderId:     rf.me,
-				PreLogIndex:  len(rf.Log) - 1,
-				PreLogTerm:   rf.Log[len(rf.Log)-1].Term,
-				LeaderCommit: rf.commitIndex,
+		//按照follower的复制进度决定发送哪些日志，见progress.go
+		request := rf.appendRequest(i, now, commitTime)
+		go func(request AppendEntriesRequest) {
+			req := AppendEntriesArgs{
+				GroupId:      rf.gid,
+				Term:         request.Term,
+				LeaderId:     request.LeaderId,
+				PreLogIndex:  request.PreLogIndex,
+				PreLogTerm:   request.PreLogTerm,
+				Entries:      request.Entries,
+				LeaderCommit: request.LeaderCommit,
+				CommitTime:   request.CommitTime,
+			}
+			resp := AppendEntriesReply{}
+			var ok bool
+			if len(request.Entries) > 0 {
+				ok = rf.sendAppendEntries(request.Follower, &req, &resp)
+			} else {
+				ok = rf.sendHeartbeat(request.Follower, &req, &resp)
 			}
+			rf.mu.Lock()
+			if ok {
+				rf.ackLeadership(request, resp)
+				rf.handleReply(request, resp, func(rf *Raft, req AppendEntriesRequest, resp AppendEntriesReply) {
+					//过期的响应（已不是发送请求时那个term的leader）直接忽略
+					if rf.state != Leader || rf.CurrentTerm != req.Term {
+						return
+					}
 
-			go func(request AppendEntriesRequest) {
-				req := AppendEntriesArgs{
-					Term:         request.Term,
-					LeaderId:     request.LeaderId,
-					PreLogIndex:  request.PreLogIndex,
-					PreLogTerm:   request.PreLogTerm,
-					LeaderCommit: request.LeaderCommit,
-				}
-				resp := AppendEntriesReply{}
-				ok := rf.sendAppendEntries(request.Follower, &req, &resp)
-				rf.mu.Lock()
-				if ok {
-					rf.handleReply(request, resp, func(rf *Raft, req AppendEntriesRequest, resp AppendEntriesReply) {
-						//Do Nothing
-					}, rf.turnFollowerFunc(), rf.decreaseNextIndexFunc())
-				}
-				rf.mu.Unlock()
-			}(request)
-		}
+					//响应可能乱序到达，matchIndex和nextIndex只前进不后退
+					match := req.PreLogIndex + len(req.Entries)
+					rf.matchIndex[req.Follower] = maxInt(rf.matchIndex[req.Follower], match)
+					rf.nextIndex[req.Follower] = maxInt(rf.nextIndex[req.Follower], match+1)
+					//找到了匹配的位置，开始稳定复制
+					rf.progress[req.Follower] = ProgressReplicate
+
+					rf.advanceCommitIndex()
+				}, rf.turnFollowerFunc(), rf.decreaseNextIndexFunc())
+			} else {
+				rf.appendUnreachable(request)
+			}
+			rf.check("AppendEntries reply")
+			rf.mu.Unlock()
+		}(request)
 	}
 	rf.mu.Unlock()
 }
@@ -614,6 +740,8 @@ func (rf *Raft) Kill() {
 	//等待所有goroutine退出
 	rf.mu.Lock()
 	rf.done = true
+	//被暂停的goroutine需要恢复才能退出
+	rf.unfreeze()
 	rf.mu.Unlock()
 	//rf.workQ.Wait()
 }
@@ -622,12 +750,14 @@ func (rf *Raft) Kill() {
 func (rf *Raft) preCheck() {
 	rf.mu.Lock()
 	if rf.commitIndex > rf.lastApplied {
-		lastApplied := rf.lastApplied
-		commitIndex := rf.commitIndex
-		log := rf.Log
+		msgs := rf.applyMsgs(rf.lastApplied+1, rf.commitIndex)
 		rf.lastApplied = rf.commitIndex
+		rf.check("apply")
+		halted := rf.violation != ""
 		rf.mu.Unlock()
-		rf.apply(log, lastApplied+1, commitIndex)
+		if !halted {
+			rf.apply(msgs)
+		}
 	} else {
 		rf.mu.Unlock()
 	}
@@ -635,6 +765,7 @@ func (rf *Raft) preCheck() {
 
 func (rf *Raft) server() {
 	for !rf.isDone() {
+		rf.waitIfPaused()
 		rf.preCheck()
 		switch rf.SyncState() {
 		case Leader:
@@ -656,7 +787,7 @@ func debug(format string, a ...interface{}) {
 }
 
 func (rf *Raft) resetElectionTimeout() {
-	rf.electionTimeout = time.Millisecond * time.Duration(rand.Intn(150)+150)
+	rf.electionTimeout = time.Millisecond * time.Duration(rf.clock.Intn(150)+150)
 }
 
 func (rf *Raft) synctElectionTimeout() time.Duration {
@@ -667,15 +798,18 @@ func (rf *Raft) synctElectionTimeout() time.Duration {
 
 func (rf *Raft) serverAsLeader() {
 	rf.broadcastAppendEntries()
-	time.Sleep(50 * time.Millisecond)
+	rf.clock.Sleep(50 * time.Millisecond)
 }
 
 func (rf *Raft) serverAsCandidate() {
 	rf.broadcastRequestVotes()
 	select {
-	case <-time.Tick(rf.synctElectionTimeout()):
+	case <-rf.clock.After(rf.synctElectionTimeout()):
+		//暂停期间超时的定时器，恢复后才生效
+		rf.waitIfPaused()
 		rf.mu.Lock()
 		rf.turnCandidate()
+		rf.check("election timeout")
 		rf.mu.Unlock()
 	case <-rf.heartbeatNotify:
 		//收到通知，发现心跳
@@ -686,9 +820,12 @@ func (rf *Raft) serverAsCandidate() {
 
 func (rf *Raft) serverAsFollower() {
 	select {
-	case <-time.Tick(rf.synctElectionTimeout()):
+	case <-rf.clock.After(rf.synctElectionTimeout()):
+		//暂停期间超时的定时器，恢复后才生效
+		rf.waitIfPaused()
 		rf.mu.Lock()
 		rf.turnCandidate()
+		rf.check("election timeout")
 		rf.mu.Unlock()
 	case <-rf.voteNotify:
 		//收到投票请求，状态不变
@@ -698,13 +835,28 @@ func (rf *Raft) serverAsFollower() {
 }
 
 func (rf *Raft) sendAppendEntries(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool {
-	return rf.peers[server].Call("Raft.AppendEntries", args, reply)
+	rf.waitIfPaused()
+	ok := rf.peers[server].Call("Raft.AppendEntries", args, reply)
+	rf.waitIfPaused()
+	return ok
+}
+
+// sendHeartbeat 发送不带日志的心跳；multi-raft下交给MultiRaft与其它group的心跳合并发送
+func (rf *Raft) sendHeartbeat(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool {
+	if rf.heartbeats != nil {
+		rf.waitIfPaused()
+		ok := rf.heartbeats(server, args, reply)
+		rf.waitIfPaused()
+		return ok
+	}
+	return rf.sendAppendEntries(server, args, reply)
 }
 
 func (rf *Raft) turnCandidate() {
 	rf.CurrentTerm++    //inc CurrentTerm
 	rf.VotedFor = rf.me //vote for selft
 	rf.votedCount = 1
+	rf.leaderId = NoLeader
 	rf.resetElectionTimeout()
 	rf.state = Candidate
 	debug("====>[%d] %d server as candidate and timeout is %+v", rf.CurrentTerm, rf.me, rf.electionTimeout)
@@ -732,6 +884,9 @@ func (rf *Raft) reinitialize() {
 		rf.nextIndex[i] = len(rf.Log)
 		//初始化为0
 		rf.matchIndex[i] = 0
+		rf.ackTime[i] = 0
+		//还不知道各follower匹配到哪里，从probe开始
+		rf.progress[i] = ProgressProbe
 	}
 }
 
@@ -743,7 +898,14 @@ func notifyChannelListener(c chan bool) {
 
 func (rf *Raft) broadcastRequestVotes() {
 	rf.mu.Lock()
-	for i := range rf.peers {
+	//只有一个成员的集群（ForceNewCluster之后）自己的一票就是多数
+	if rf.state == Candidate && rf.votedCount >= rf.quorum() {
+		rf.turnLeader()
+		notifyChannelListener(rf.electLeaderNotify)
+		rf.mu.Unlock()
+		return
+	}
+	for _, i := range rf.voters() {
 		if i != rf.me {
 			request := RequestVotesRequest{
 				Target:       i,
@@ -756,6 +918,7 @@ func (rf *Raft) broadcastRequestVotes() {
 			//只有请求成功再计算票数
 			go func(request RequestVotesRequest) {
 				req := RequestVoteArgs{
+					GroupId:      rf.gid,
 					Term:         request.Term,
 					CandidatId:   request.Candidate,
 					LastLogIndex: request.LastLogIndex,
@@ -764,15 +927,17 @@ func (rf *Raft) broadcastRequestVotes() {
 				var resp RequestVoteReply
 				ok := rf.sendRequestVote(request.Target, &req, &resp)
 				rf.mu.Lock()
-				if rf.state == Candidate {
+				//只统计本轮选举（发送请求时的term）得到的选票，之前term的迟到选票不能计入
+				if rf.state == Candidate && rf.CurrentTerm == request.Term {
 					if ok && resp.VoteGranted {
 						rf.votedCount++
 					}
-					if rf.votedCount > len(rf.peers)/2 {
+					if rf.votedCount >= rf.quorum() {
 						rf.turnLeader()
 						notifyChannelListener(rf.electLeaderNotify)
 					}
 				}
+				rf.check("RequestVote reply")
 				rf.mu.Unlock()
 			}(request)
 
@@ -794,7 +959,24 @@ func (rf *Raft) broadcastRequestVotes() {
 //
 func Make(peers []*labrpc.ClientEnd, me int,
 	persister *Persister, applyCh chan ApplyMsg) *Raft {
+	return MakeWithClock(peers, me, persister, applyCh, labrpc.MakeRealClock())
+}
+
+// MakeWithClock 与Make相同，但所有的计时和随机数都取自clock，
+// 与labrpc.Network使用同一个模拟时钟时，一次运行可以由种子复现
+func MakeWithClock(peers []*labrpc.ClientEnd, me int,
+	persister *Persister, applyCh chan ApplyMsg, clock labrpc.Clock) *Raft {
+	rf := newRaft(peers, me, persister, applyCh, clock)
+	go rf.server()
+
+	return rf
+}
+
+// newRaft 创建并初始化raft，但还没有启动后台任务，调用方可以在启动前做额外的设置（见MultiRaft）
+func newRaft(peers []*labrpc.ClientEnd, me int,
+	persister *Persister, applyCh chan ApplyMsg, clock labrpc.Clock) *Raft {
 	rf := &Raft{}
+	rf.clock = clock
 	rf.peers = peers
 	rf.persister = persister
 	rf.me = me
@@ -809,14 +991,16 @@ func Make(peers []*labrpc.ClientEnd, me int,
 	rf.Log = []LogEntry{{}} //初始化空日志，保证第一个日志的索引为1
 	rf.nextIndex = make([]int, len(rf.peers))
 	rf.matchIndex = make([]int, len(rf.peers))
+	rf.ackTime = make([]int64, len(rf.peers))
+	rf.progress = make([]string, len(rf.peers))
 	rf.commitIndex = 0
 	rf.lastApplied = 0
+	rf.sessions = MakeSessionTable(DefaultSessionExpiry)
 	rf.applyCh = applyCh
 	rf.done = false
 
 	// initialize from state persisted before a crash
 	rf.readPersist(persister.ReadRaftState())
-	go rf.server()
 
 	return rf
 }
@@ -1,14 +1,23 @@
 package raft
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"hadoop-raft/labrpc"
+	"io/ioutil"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 var serverCfg *config
 
+// 集群提交接口默认的超时时间
+const submitTimeout = 3 * time.Second
+
 // Hello hello world
 func Hello(c *gin.Context) {
 	c.JSON(200, gin.H{
@@ -17,6 +26,10 @@ func Hello(c *gin.Context) {
 }
 
 // StartNodes 初始化网络以及节点
+// 可选参数seed：带上时使用以seed为种子的模拟时钟，时间只在调用/api/clock/advance时前进，同一个seed可以复现一次运行
+// 可选参数netSeed：不带seed时使用真实时钟，只用netSeed固定网络的随机选择，可以复现不可靠网络上的丢包和延迟
+// 可选参数skew：逗号分隔的各节点时钟速率，例如skew=1,1.5,0.5，缺省为1
+// 返回的netSeed是网络实际使用的种子，不带seed和netSeed时也会返回，可以用它复现这次运行的网络
 func StartNodes(c *gin.Context) {
 	if serverCfg != nil {
 		c.JSON(200, gin.H{
@@ -25,9 +38,24 @@ func StartNodes(c *gin.Context) {
 	}
 	s := c.Query("servers")
 	servers, _ := strconv.ParseInt(s, 10, 64)
-	serverCfg = make_config(nil, int(servers), false)
+	if seed, err := strconv.ParseInt(c.Query("seed"), 10, 64); err == nil {
+		serverCfg = make_config_clock(nil, int(servers), false, labrpc.MakeSimClock(seed))
+	} else if seed, err := strconv.ParseInt(c.Query("netSeed"), 10, 64); err == nil {
+		//真实时钟下只固定网络的随机选择（丢包、延迟和乱序）
+		serverCfg = make_config_clock(nil, int(servers), false, labrpc.MakeRealClock(), labrpc.WithSeed(seed))
+	} else {
+		serverCfg = make_config(nil, int(servers), false)
+	}
+	if skew := c.Query("skew"); skew != "" {
+		for i, r := range strings.Split(skew, ",") {
+			if rate, err := strconv.ParseFloat(r, 64); err == nil && rate > 0 && i < serverCfg.n {
+				serverCfg.setrate(i, rate)
+			}
+		}
+	}
 	c.JSON(200, gin.H{
-		"msg": "success!",
+		"msg":     "success!",
+		"netSeed": serverCfg.net.Seed(),
 	})
 }
 
@@ -63,11 +91,343 @@ func ReconnectNode(c *gin.Context) {
 	})
 }
 
+// PartitionNodes 把节点划分为命名的分区，每个查询参数是一个分区，例如a=0,1&b=2,3,4。
+// 同一分区内的节点可以通信，不同分区之间的消息全部丢失；不在任何分区中的节点与其它节点都不通，没有任何节点的划分会被拒绝，取消分区请用heal
+func PartitionNodes(c *gin.Context) {
+	groups := map[string][]int{}
+	for name, values := range c.Request.URL.Query() {
+		groups[name] = []int{}
+		for _, v := range values {
+			for _, f := range strings.Split(v, ",") {
+				if f = strings.TrimSpace(f); f == "" {
+					continue
+				}
+				number, err := strconv.Atoi(f)
+				if err != nil {
+					c.JSON(200, gin.H{
+						"msg": fmt.Sprintf("partition %s: bad node %q", name, f),
+					})
+					return
+				}
+				groups[name] = append(groups[name], number)
+			}
+		}
+	}
+	if err := serverCfg.partition(groups); err != nil {
+		c.JSON(200, gin.H{
+			"msg": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"msg":        "success!",
+		"partitions": serverCfg.net.Partitions(),
+	})
+}
+
+// HealNodes 取消分区，所有未断开的节点之间恢复通信
+func HealNodes(c *gin.Context) {
+	serverCfg.heal()
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// SetLink 设置从节点from到节点to这一方向链路的网络状况：每条消息的延迟在minDelay和maxDelay毫秒之间均匀分布，
+// 以drop的概率丢失，请求以duplicate的概率被执行两次（副本的响应被丢弃）。请求走from到to的链路，响应走反方向的链路
+func SetLink(c *gin.Context) {
+	from, _ := strconv.Atoi(c.Query("from"))
+	to, _ := strconv.Atoi(c.Query("to"))
+	ms := func(key string) time.Duration {
+		v, _ := strconv.ParseFloat(c.Query(key), 64)
+		return time.Duration(v * float64(time.Millisecond))
+	}
+	lc := labrpc.LinkConfig{MinDelay: ms("minDelay"), MaxDelay: ms("maxDelay")}
+	lc.Drop, _ = strconv.ParseFloat(c.Query("drop"), 64)
+	lc.Duplicate, _ = strconv.ParseFloat(c.Query("duplicate"), 64)
+	if c.Query("maxDelay") == "" {
+		//只给出minDelay时延迟是固定的
+		lc.MaxDelay = lc.MinDelay
+	}
+	if err := serverCfg.setlink(from, to, lc); err != nil {
+		c.JSON(200, gin.H{
+			"msg": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"msg":   "success!",
+		"links": links(),
+	})
+}
+
+// ClearLink 恢复从节点from到节点to的链路
+func ClearLink(c *gin.Context) {
+	from, _ := strconv.Atoi(c.Query("from"))
+	to, _ := strconv.Atoi(c.Query("to"))
+	serverCfg.clearlink(from, to)
+	c.JSON(200, gin.H{
+		"msg":   "success!",
+		"links": links(),
+	})
+}
+
+// CutLink 切断单向链路：给出from和to时切断从from到to的链路，from的请求和from发给to的响应都会丢失，
+// 反方向仍然可用；给出number和direction时，direction为in切断所有发往该节点的链路（只能发不能收），
+// 为out切断所有从该节点发出的链路（只能收不能发）
+func CutLink(c *gin.Context) {
+	enableLinks(c, false)
+}
+
+// RestoreLink 恢复CutLink切断的链路，参数与CutLink相同；direction为空时恢复该节点两个方向的所有链路
+func RestoreLink(c *gin.Context) {
+	enableLinks(c, true)
+}
+
+func enableLinks(c *gin.Context, enabled bool) {
+	var err error
+	if c.Query("number") != "" {
+		number, _ := strconv.Atoi(c.Query("number"))
+		switch direction := c.Query("direction"); {
+		case number < 0 || number >= serverCfg.n:
+			err = fmt.Errorf("no node %d", number)
+		case direction == "in" || direction == "out":
+			for j := 0; j < serverCfg.n; j++ {
+				if j == number {
+					continue
+				}
+				if direction == "in" {
+					serverCfg.enablelink(j, number, enabled)
+				} else {
+					serverCfg.enablelink(number, j, enabled)
+				}
+			}
+		case enabled:
+			serverCfg.restorelinks(number)
+		default:
+			err = fmt.Errorf("direction must be in or out")
+		}
+	} else {
+		from, _ := strconv.Atoi(c.Query("from"))
+		to, _ := strconv.Atoi(c.Query("to"))
+		err = serverCfg.enablelink(from, to, enabled)
+	}
+	if err != nil {
+		c.JSON(200, gin.H{
+			"msg": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"msg":   "success!",
+		"links": links(),
+	})
+}
+
+// GetLinks 获取所有设置了网络状况或被切断的链路，延迟的单位为毫秒
+func GetLinks(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"links": links(),
+	})
+}
+
+func links() []gin.H {
+	ls := []gin.H{}
+	for _, l := range serverCfg.net.Links() {
+		ls = append(ls, gin.H{
+			"from":      l.From,
+			"to":        l.To,
+			"minDelay":  float64(l.MinDelay) / float64(time.Millisecond),
+			"maxDelay":  float64(l.MaxDelay) / float64(time.Millisecond),
+			"drop":      l.Drop,
+			"duplicate": l.Duplicate,
+			"disabled":  l.Disabled,
+		})
+	}
+	return ls
+}
+
+// SetClockRate 设置编号为number的节点的时钟速率rate，rate>1时该节点的选举超时和心跳都走得更快，rate<1时更慢
+func SetClockRate(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	rate, err := strconv.ParseFloat(c.Query("rate"), 64)
+	if err != nil || rate <= 0 {
+		c.JSON(200, gin.H{
+			"msg": "rate must be a positive number",
+		})
+		return
+	}
+	serverCfg.setrate(number, rate)
+	c.JSON(200, gin.H{
+		"msg":  "success!",
+		"rate": rate,
+	})
+}
+
+// ForceNewCluster 不安全的灾难恢复操作：多数派永久丢失后，把members中的幸存节点改写为一个更小的新集群，
+// 保留它们的日志。只在丢失节点上提交过的日志会丢失。不在members中的节点必须都已断开，
+// 它们仍然保留旧的配置，重连后可能选出自己的leader，所以之后不能再重连它们
+func ForceNewCluster(c *gin.Context) {
+	var members []int
+	for _, m := range strings.Split(c.Query("members"), ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(m))
+		if err != nil {
+			c.JSON(200, gin.H{
+				"msg": "members must be a comma separated list of node numbers",
+			})
+			return
+		}
+		members = append(members, i)
+	}
+	rewritten, err := serverCfg.forceNewCluster(members)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"msg":       "failed: " + err.Error(),
+			"rewritten": rewritten,
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"msg":       "success!",
+		"members":   members,
+		"rewritten": rewritten,
+	})
+}
+
+// Backup 导出编号为number的节点持久化的状态（raft state和snapshot）为归档，不带number时导出所有节点
+func Backup(c *gin.Context) {
+	if s := c.Query("number"); s != "" {
+		number, err := strconv.Atoi(s)
+		if err != nil || number < 0 || number >= serverCfg.n {
+			c.JSON(200, gin.H{
+				"msg": "invalid node number",
+			})
+			return
+		}
+		a, err := serverCfg.backup(number)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"msg": err.Error(),
+			})
+			return
+		}
+		c.JSON(200, a)
+		return
+	}
+	archives := make([]*Archive, serverCfg.n)
+	for i := range archives {
+		a, err := serverCfg.backup(i)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"msg": err.Error(),
+			})
+			return
+		}
+		archives[i] = a
+	}
+	c.JSON(200, archives)
+}
+
+// Restore 用请求体中的归档恢复节点：节点以归档中的状态重启并重连。
+// 请求体是单个归档时恢复到number指定的节点（缺省为归档记录的节点），是Backup导出的数组时恢复整个集群
+func Restore(c *gin.Context) {
+	body, _ := ioutil.ReadAll(c.Request.Body)
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(body, &raw); err != nil {
+			c.JSON(200, gin.H{
+				"msg": err.Error(),
+			})
+			return
+		}
+		archives := make([]*Archive, len(raw))
+		for i := range raw {
+			a, err := ReadArchive(bytes.NewReader(raw[i]))
+			if err != nil {
+				c.JSON(200, gin.H{
+					"msg": fmt.Sprintf("archive %d: %v", i, err),
+				})
+				return
+			}
+			archives[i] = a
+		}
+		if err := serverCfg.restoreAll(archives); err != nil {
+			c.JSON(200, gin.H{
+				"msg": err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"msg": "success!",
+		})
+		return
+	}
+
+	a, err := ReadArchive(bytes.NewReader(body))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"msg": err.Error(),
+		})
+		return
+	}
+	number := a.Node
+	if s := c.Query("number"); s != "" {
+		number, _ = strconv.Atoi(s)
+	}
+	if number < 0 || number >= serverCfg.n {
+		c.JSON(200, gin.H{
+			"msg": "invalid node number",
+		})
+		return
+	}
+	if err := serverCfg.restore(number, a); err != nil {
+		c.JSON(200, gin.H{
+			"msg": err.Error(),
+		})
+		return
+	}
+	serverCfg.connect(number)
+	c.JSON(200, gin.H{
+		"msg":       "success!",
+		"number":    number,
+		"term":      a.Term,
+		"lastIndex": a.LastIndex,
+	})
+}
+
+// PauseNode 暂停编号为number的节点，网络连接保持不变，模拟GC停顿或虚拟机卡顿
+func PauseNode(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	serverCfg.pause(number)
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
+// ResumeNode 恢复编号为number的节点，节点带着暂停前的内存状态继续运行
+func ResumeNode(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	serverCfg.resume(number)
+	c.JSON(200, gin.H{
+		"msg": "success!",
+	})
+}
+
 // GetState 获取编号为number的节点状态
 func GetState(c *gin.Context) {
 	s := c.Query("number")
 	number := 0
 	fmt.Sscanf(s, "%d", &number)
+	serverCfg.mu.Lock()
+	rate := serverCfg.rates[number]
+	serverCfg.mu.Unlock()
 	serverCfg.rafts[number].mu.Lock()
 	defer serverCfg.rafts[number].mu.Unlock()
 	// term, leader := serverCfg.rafts[number].GetState()
@@ -80,46 +440,292 @@ func GetState(c *gin.Context) {
 		"votedCount":  serverCfg.rafts[number].votedCount,
 		"leaderId":    serverCfg.rafts[number].leaderId,
 		"logs":        serverCfg.rafts[number].Log,
+		"times":       serverCfg.rafts[number].times,
 		"commitIndex": serverCfg.rafts[number].commitIndex,
 		"lastApplied": serverCfg.rafts[number].lastApplied,
+		"sessions":    serverCfg.rafts[number].sessions.Sessions(),
+		"applyErr":    serverCfg.applyErr[number],
+		"forwarding":  serverCfg.rafts[number].forwarding,
+		"limits":      serverCfg.rafts[number].limits,
+		"paused":      serverCfg.rafts[number].resume != nil,
+		"clockRate":   rate,
+		"partition":   serverCfg.partitionOf(number),
+		"members":     serverCfg.rafts[number].voters(),
+		"progress":    serverCfg.rafts[number].progressLocked(),
+		"checked":     serverCfg.rafts[number].checked,
+		"violation":   serverCfg.rafts[number].violation,
+	})
+}
+
+// StaleRead 不经过日志，直接读编号为number的节点已apply的状态，返回读证明中的index、该index上的command，
+// 以及读到的状态最多落后的毫秒数。可选参数maxStaleness（毫秒）：超过时err为"too stale"，缺省不限制。
+// 被断开的节点仍然可以读，落后的时间会随分区时间增长
+func StaleRead(c *gin.Context) {
+	s := c.Query("number")
+	number := 0
+	fmt.Sscanf(s, "%d", &number)
+	maxStaleness := time.Duration(0)
+	if ms, err := strconv.ParseInt(c.Query("maxStaleness"), 10, 64); err == nil {
+		maxStaleness = time.Duration(ms) * time.Millisecond
+	}
+
+	//被暂停的节点不会响应，直接返回而不是一直等待
+	if serverCfg.rafts[number].Paused() {
+		c.JSON(200, gin.H{
+			"number": number,
+			"err":    "paused",
+		})
+		return
+	}
+
+	rs, v := serverCfg.staleRead(number, maxStaleness)
+	c.JSON(200, gin.H{
+		"number":      number,
+		"index":       rs.Index,
+		"applied":     rs.Applied,
+		"value":       v,
+		"stalenessMs": float64(rs.Staleness) / float64(time.Millisecond),
+		"leader":      rs.Leader,
+		"err":         rs.Err,
+	})
+}
+
+// SetLimits 设置所有节点作为leader时接受命令的限制：maxEntryBytes单条命令的最大字节数，
+// maxUncommittedEntries、maxUncommittedBytes未提交日志的最大条数和总字节数，缺省或为0表示不限制。
+// 超过限制的command不会被追加，startcommand和submit返回err为"overloaded"或"entry too large"
+func SetLimits(c *gin.Context) {
+	var l Limits
+	l.MaxEntryBytes, _ = strconv.Atoi(c.Query("maxEntryBytes"))
+	l.MaxUncommittedEntries, _ = strconv.Atoi(c.Query("maxUncommittedEntries"))
+	l.MaxUncommittedBytes, _ = strconv.Atoi(c.Query("maxUncommittedBytes"))
+	serverCfg.setlimits(l)
+	c.JSON(200, gin.H{
+		"msg":    "success!",
+		"limits": l,
+	})
+}
+
+// SetChecked 开启或关闭所有节点的检查模式。开启后节点在每次状态变化后检查自身的不变量，
+// 发现违反时停止运行，getstate中的violation是详细的报告
+func SetChecked(c *gin.Context) {
+	on := c.Query("enable") == "true"
+	serverCfg.setchecked(on)
+	c.JSON(200, gin.H{
+		"msg":     "success!",
+		"checked": on,
+	})
+}
+
+// SetForwarding 开启或关闭所有节点的转发模式，开启后向follower发送的command会被转发给leader
+func SetForwarding(c *gin.Context) {
+	on := c.Query("enable") == "true"
+	serverCfg.setforwarding(on)
+	c.JSON(200, gin.H{
+		"msg":        "success!",
+		"forwarding": on,
 	})
 }
 
 // StartCommand 向某一节点发送command请求
+// command不做解析，原样作为Payload复制；POST请求时command取自请求体
+// 可选参数clientId和seq：带上它们时，同一client重试的相同seq只会被应用一次
+// 转发模式下follower把command转发给leader，返回leader上的index和term，不知道leader时err为"no leader"
 func StartCommand(c *gin.Context) {
 	command := c.Query("command")
+	if c.Request.Method == "POST" {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		command = string(body)
+	}
 	s := c.Query("number")
 	number := 0
 	fmt.Sscanf(s, "%d", &number)
-	cmd, _ := strconv.ParseInt(command, 10, 64)
+	cmd := Payload(command)
+	clientId, _ := strconv.ParseInt(c.Query("clientId"), 10, 64)
+	seq, _ := strconv.ParseInt(c.Query("seq"), 10, 64)
 
 	if !serverCfg.connected[number] {
 		c.JSON(200, gin.H{
 			"index":    -1,
 			"term":     -1,
 			"isLeader": false,
+			"leaderId": serverCfg.rafts[number].GetLeader(),
+			"err":      "disconnected",
+		})
+		return
+	}
+
+	//被暂停的节点不会响应，直接返回而不是一直等待
+	if serverCfg.rafts[number].Paused() {
+		c.JSON(200, gin.H{
+			"index":    -1,
+			"term":     -1,
+			"isLeader": false,
+			"leaderId": NoLeader,
+			"err":      "paused",
+		})
+		return
+	}
+
+	p := serverCfg.rafts[number].Propose(clientId, seq, cmd)
+	c.JSON(200, gin.H{
+		"index":     p.Index,
+		"term":      p.Term,
+		"isLeader":  !p.Forwarded && p.LeaderId == number,
+		"forwarded": p.Forwarded,
+		"leaderId":  p.LeaderId,
+		"err":       p.Err,
+	})
+}
+
+// Submit 向整个集群提交command，不需要指定节点。
+// 从上次的leader开始，按照各节点返回的leaderId重定向，选举期间不断重试直到超时（可选参数timeout，单位毫秒）；
+// 返回接受该command的leader，以及依次尝试过的每个节点的结果
+func Submit(c *gin.Context) {
+	command := c.Query("command")
+	if c.Request.Method == "POST" {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		command = string(body)
+	}
+	clientId, _ := strconv.ParseInt(c.Query("clientId"), 10, 64)
+	seq, _ := strconv.ParseInt(c.Query("seq"), 10, 64)
+	timeout := submitTimeout
+	if ms, err := strconv.ParseInt(c.Query("timeout"), 10, 64); err == nil {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	p, leader, attempts := serverCfg.submit(clientId, seq, Payload(command), timeout)
+	c.JSON(200, gin.H{
+		"index":    p.Index,
+		"term":     p.Term,
+		"leader":   leader,
+		"err":      p.Err,
+		"attempts": attempts,
+	})
+}
+
+// GetClock 获取集群使用的时钟：是否为模拟时钟、当前时间、种子以及等待中的定时器数
+func GetClock(c *gin.Context) {
+	sim, ok := serverCfg.clock.(*labrpc.SimClock)
+	if !ok {
+		c.JSON(200, gin.H{
+			"simulated": false,
+			"now":       serverCfg.clock.Now().UnixNano(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"simulated": true,
+		"now":       sim.Now().UnixNano(),
+		"seed":      sim.Seed(),
+		"pending":   sim.Pending(),
+	})
+}
+
+// AdvanceClock 把模拟时钟向前推进ms毫秒；step=true时只推进到下一个定时器
+func AdvanceClock(c *gin.Context) {
+	sim, ok := serverCfg.clock.(*labrpc.SimClock)
+	if !ok {
+		c.JSON(200, gin.H{
+			"msg": "not a simulated clock",
 		})
 		return
 	}
+	if c.Query("step") == "true" {
+		sim.Step()
+	} else {
+		ms, _ := strconv.ParseInt(c.Query("ms"), 10, 64)
+		sim.Advance(time.Duration(ms) * time.Millisecond)
+	}
+	c.JSON(200, gin.H{
+		"msg": "success!",
+		"now": sim.Now().UnixNano(),
+	})
+}
+
+// SetManual 开启或关闭手动投递模式。开启后网络不再自动投递RPC，请求和响应都会停在队列里，
+// 关闭时队列中的消息全部投递
+func SetManual(c *gin.Context) {
+	on := c.Query("enable") == "true"
+	serverCfg.net.Manual(on)
+	c.JSON(200, gin.H{
+		"msg":    "success!",
+		"manual": on,
+	})
+}
+
+// GetMessages 获取手动模式下停在队列中的消息，from/to为发送和接收的节点编号
+func GetMessages(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"manual":   serverCfg.net.IsManual(),
+		"messages": serverCfg.held(),
+	})
+}
 
-	index, term, isLeader := serverCfg.rafts[number].Start(int(cmd))
+// MessageAction 对编号为id的消息执行action：deliver投递，drop丢弃，
+// duplicate复制一份请求（副本被执行但响应被丢弃），delay延迟ms毫秒后投递
+func MessageAction(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Query("id"))
+	ok := false
+	dup := -1
+	switch c.Param("action") {
+	case "deliver":
+		ok = serverCfg.net.Deliver(id)
+	case "drop":
+		ok = serverCfg.net.Drop(id)
+	case "duplicate":
+		dup, ok = serverCfg.net.Duplicate(id)
+	case "delay":
+		ms, _ := strconv.ParseInt(c.Query("ms"), 10, 64)
+		ok = serverCfg.net.Delay(id, time.Duration(ms)*time.Millisecond)
+	}
 	c.JSON(200, gin.H{
-		"index":    index,
-		"term":     term,
-		"isLeader": isLeader,
+		"ok":        ok,
+		"duplicate": dup,
 	})
 }
 
 // Server 创建Server
 func Server() *gin.Engine {
 	serverCfg = nil
+	multiCfg = nil
 	r := gin.Default()
 	r.GET("/api/startnodes", StartNodes)
 	r.GET("/api/cleannodes", CleanNodes)
 	r.GET("/api/disconnect", DisconnectNode)
 	r.GET("/api/reconnect", ReconnectNode)
+	r.GET("/api/partition", PartitionNodes)
+	r.GET("/api/heal", HealNodes)
+	r.GET("/api/link", SetLink)
+	r.GET("/api/link/clear", ClearLink)
+	r.GET("/api/link/cut", CutLink)
+	r.GET("/api/link/restore", RestoreLink)
+	r.GET("/api/links", GetLinks)
 	r.GET("/api/getstate", GetState)
+	r.GET("/api/pause", PauseNode)
+	r.GET("/api/forcenewcluster", ForceNewCluster)
+	r.GET("/api/backup", Backup)
+	r.POST("/api/restore", Restore)
+	r.GET("/api/skew", SetClockRate)
+	r.GET("/api/resume", ResumeNode)
 	r.GET("/api/startcommand", StartCommand)
+	r.POST("/api/startcommand", StartCommand)
+	r.GET("/api/forwarding", SetForwarding)
+	r.GET("/api/limits", SetLimits)
+	r.GET("/api/checked", SetChecked)
+	r.GET("/api/read", StaleRead)
+	r.GET("/api/submit", Submit)
+	r.GET("/api/clock", GetClock)
+	r.GET("/api/clock/advance", AdvanceClock)
+	r.GET("/api/manual", SetManual)
+	r.GET("/api/messages", GetMessages)
+	r.GET("/api/messages/:action", MessageAction)
+	r.POST("/api/submit", Submit)
+	r.GET("/api/multi/startnodes", MultiStartNodes)
+	r.GET("/api/multi/cleannodes", MultiCleanNodes)
+	r.GET("/api/multi/disconnect", MultiDisconnectNode)
+	r.GET("/api/multi/reconnect", MultiReconnectNode)
+	r.GET("/api/multi/getstate", MultiGetState)
+	r.GET("/api/multi/startcommand", MultiStartCommand)
 	r.Static("/index", "./frontend")
 	return r
 }
@@ -0,0 +1,94 @@
+package raft
+
+import (
+	"sort"
+	"time"
+)
+
+//
+// client会话：client为每个命令分配单调递增的序号，并随日志一起复制。
+// 各节点在apply时维护同一张会话表，同一client序号不大于已应用序号的命令即为重复请求，
+// 状态机应该忽略它，从而保证client重试时命令只生效一次。
+// 会话的活跃时间取自日志中leader记录的时间戳，所以各节点的过期判断是一致的。
+//
+
+// DefaultSessionExpiry 会话空闲超过该时长后过期
+const DefaultSessionExpiry = time.Minute
+
+// NoClient 表示命令不属于任何client会话，不做去重
+const NoClient = 0
+
+type Session struct {
+	ClientId   int64 // client id
+	LastSeq    int64 // 最近一次应用的命令序号
+	LastIndex  int   // 最近一次应用的命令所在的日志index
+	LastActive int64 // 最近一次活跃的时间(UnixNano)
+}
+
+type SessionTable struct {
+	expiry   time.Duration
+	sessions map[int64]*Session
+}
+
+func MakeSessionTable(expiry time.Duration) *SessionTable {
+	return &SessionTable{
+		expiry:   expiry,
+		sessions: map[int64]*Session{},
+	}
+}
+
+// Apply 按日志顺序应用一条日志，返回它是否是重复请求，
+// 以及该请求第一次被应用时的index（无法确定时为-1）
+func (st *SessionTable) Apply(index int, entry LogEntry) (bool, int) {
+	st.expire(entry.Timestamp)
+
+	if entry.ClientId == NoClient {
+		return false, index
+	}
+
+	s, ok := st.sessions[entry.ClientId]
+	if !ok {
+		s = &Session{ClientId: entry.ClientId}
+		st.sessions[entry.ClientId] = s
+	}
+	s.LastActive = maxInt64(s.LastActive, entry.Timestamp)
+
+	if ok && entry.Seq <= s.LastSeq {
+		if entry.Seq == s.LastSeq {
+			return true, s.LastIndex
+		}
+		return true, -1
+	}
+
+	s.LastSeq = entry.Seq
+	s.LastIndex = index
+	return false, index
+}
+
+// expire 删除在now之前空闲超过expiry的会话
+func (st *SessionTable) expire(now int64) {
+	for id, s := range st.sessions {
+		if now-s.LastActive > int64(st.expiry) {
+			delete(st.sessions, id)
+		}
+	}
+}
+
+// Sessions 返回按client id排序的会话列表
+func (st *SessionTable) Sessions() []Session {
+	sessions := make([]Session, 0, len(st.sessions))
+	for _, s := range st.sessions {
+		sessions = append(sessions, *s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ClientId < sessions[j].ClientId
+	})
+	return sessions
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,94 @@
+package raft
+
+import (
+	"sort"
+	"time"
+)
+
+//
+// 有界陈旧读：不经过日志，直接用本节点已apply的状态响应读请求，同时给出读到的状态最多落后多久。
+// leader记录每个节点最近一次确认本term的AppendEntries的发送时间，多数派都确认过的最晚时间T之前，
+// 不会有更大term的leader提交日志，所以T之前提交的日志都不超过leader的commitIndex。
+// leader把T和commitIndex随AppendEntries带给follower，follower apply到该index后，
+// 读到的状态最多落后(本地时间-T)。被分区的旧leader和follower上T不再前进，陈旧程度随之增长。
+// T取自leader的时钟，follower用自己的时钟计算落后的时间，节点间的时钟偏差会直接计入结果
+//
+
+// StaleRead的错误，为空表示可以提供读
+const (
+	ErrNoReadProof = "no read proof" //没有收到过leader的读证明，或leader在本term还没有提交日志、没有被多数派确认
+	ErrTooStale    = "too stale"     //读证明已经超过了允许的陈旧程度
+	ErrNotApplied  = "not applied"   //还没有apply到读证明中的index
+)
+
+// ReadState 一次本地读的依据：apply到Index之后，读到的状态最多落后Staleness
+type ReadState struct {
+	Index     int           `json:"index"`     //读必须反映到这个index为止的日志
+	Applied   int           `json:"applied"`   //本节点已apply的index
+	Staleness time.Duration `json:"staleness"` //读到的状态最多落后的时间
+	Leader    bool          `json:"leader"`
+	Err       string        `json:"err"`
+}
+
+// commitTime leader被多数派确认的最晚时间(UnixNano)，在此之前提交的日志都不超过commitIndex。
+// 本term还没有提交日志时，commitIndex可能落后于之前的leader提交的日志，返回0表示无法证明
+func (rf *Raft) commitTime(now int64) int64 {
+	if rf.Log[rf.commitIndex].Term != rf.CurrentTerm {
+		return 0
+	}
+	voters := rf.voters()
+	acks := make([]int64, len(voters))
+	for k, i := range voters {
+		acks[k] = rf.ackTime[i]
+		if i == rf.me {
+			acks[k] = now
+		}
+	}
+	sort.Slice(acks, func(a, b int) bool { return acks[a] > acks[b] })
+	return acks[rf.quorum()-1]
+}
+
+// ackLeadership 记录follower对本term的AppendEntries的回复，不论日志是否匹配，
+// 回复都说明它在请求发送时承认本节点是leader
+func (rf *Raft) ackLeadership(req AppendEntriesRequest, resp AppendEntriesReply) {
+	if rf.state != Leader || rf.CurrentTerm != req.Term || resp.Term != req.Term {
+		return
+	}
+	if req.Sent > rf.ackTime[req.Follower] {
+		rf.ackTime[req.Follower] = req.Sent
+	}
+}
+
+// ReadState 返回本节点当前的读证明，Err只会是ErrNoReadProof
+func (rf *Raft) ReadState() ReadState {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	now := rf.clock.Now().UnixNano()
+	rs := ReadState{Applied: rf.lastApplied, Leader: rf.state == Leader}
+	index, at := rf.readIndex, rf.readTime
+	if rs.Leader {
+		index, at = rf.commitIndex, rf.commitTime(now)
+	}
+	if at == 0 {
+		rs.Err = ErrNoReadProof
+		return rs
+	}
+	rs.Index = index
+	rs.Staleness = time.Duration(now - at)
+	return rs
+}
+
+// StaleRead 检查本节点能否用已apply的状态提供最多落后maxStaleness的读，maxStaleness<=0表示不限制
+func (rf *Raft) StaleRead(maxStaleness time.Duration) ReadState {
+	rs := rf.ReadState()
+	if rs.Err != "" {
+		return rs
+	}
+	if maxStaleness > 0 && rs.Staleness > maxStaleness {
+		rs.Err = ErrTooStale
+	} else if rs.Applied < rs.Index {
+		rs.Err = ErrNotApplied
+	}
+	return rs
+}
@@ -0,0 +1,62 @@
+package raft
+
+import "sort"
+
+//
+// 日志的term是单调不减的，所以同一个term的日志在Log中一定是连续的一段，
+// term的边界可以直接在Log上二分查找，冲突处理时不需要再从头线性扫描。
+//
+
+// firstIndexOfTerm 返回term为指定值的第一条日志的索引，不存在时返回-1
+func firstIndexOfTerm(log []LogEntry, term int) int {
+	i := sort.Search(len(log), func(i int) bool {
+		return log[i].Term >= term
+	})
+	if i < len(log) && log[i].Term == term {
+		return i
+	}
+	return -1
+}
+
+// lastIndexOfTerm 返回term为指定值的最后一条日志的索引，不存在时返回-1
+func lastIndexOfTerm(log []LogEntry, term int) int {
+	i := sort.Search(len(log), func(i int) bool {
+		return log[i].Term > term
+	})
+	if i > 0 && log[i-1].Term == term {
+		return i - 1
+	}
+	return -1
+}
+
+// conflictHint follower在PreLogIndex处与leader日志不一致时，
+// 返回冲突日志的term以及该term第一条日志的索引
+func (rf *Raft) conflictHint(preLogIndex int) (conflictIndex, conflictTerm int) {
+	if preLogIndex >= len(rf.Log) {
+		//由于不含有PreLogIndex位置的日志，也就是还没有发现冲突日志，可以认为冲突index为日志长度，冲突term为nil（-1）
+		return len(rf.Log), -1
+	}
+	conflictTerm = rf.Log[preLogIndex].Term
+	return firstIndexOfTerm(rf.Log, conflictTerm), conflictTerm
+}
+
+// nextIndexForConflict leader根据follower返回的冲突信息计算新的nextIndex：
+// 如果leader也有冲突term的日志，则从该term最后一条日志的下一条开始发送；
+// 否则直接跳到follower上冲突term的第一条日志
+func (rf *Raft) nextIndexForConflict(conflictIndex, conflictTerm int) int {
+	next := conflictIndex
+	if conflictTerm >= 0 {
+		if last := lastIndexOfTerm(rf.Log, conflictTerm); last >= 0 {
+			next = last + 1
+		}
+	}
+
+	//nextIndex至少为1（索引0为占位日志），且不超过leader日志长度
+	if next < 1 {
+		next = 1
+	}
+	if next > len(rf.Log) {
+		next = len(rf.Log)
+	}
+	return next
+}
@@ -14,6 +14,9 @@ import "time"
 import "math/rand"
 import "sync/atomic"
 import "sync"
+import "bytes"
+import "strings"
+import "hadoop-raft/labrpc"
 
 // The tester generously allows solutions to complete elections in one second
 // (much more than the paper's range of timeouts).
@@ -927,3 +930,1073 @@ func TestReliableChurn2C(t *testing.T) {
 func TestUnreliableChurn2C(t *testing.T) {
 	internalChurn(t, true)
 }
+
+// build a log of n entries (plus the index-0 placeholder) whose
+// term advances every termLen entries.
+func makeTermLog(n int, termLen int) []LogEntry {
+	log := make([]LogEntry, n+1)
+	for i := 1; i <= n; i++ {
+		log[i] = LogEntry{Command: i, Term: 1 + (i-1)/termLen}
+	}
+	return log
+}
+
+func TestTermIndex(t *testing.T) {
+	log := makeTermLog(1000, 7)
+
+	for term := 0; term <= log[len(log)-1].Term+1; term++ {
+		first, last := -1, -1
+		for i := range log {
+			if log[i].Term == term {
+				if first == -1 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if got := firstIndexOfTerm(log, term); got != first {
+			t.Fatalf("firstIndexOfTerm(%v) = %v, expected %v", term, got, first)
+		}
+		if got := lastIndexOfTerm(log, term); got != last {
+			t.Fatalf("lastIndexOfTerm(%v) = %v, expected %v", term, got, last)
+		}
+	}
+
+	// leader uses the follower's ConflictTerm hint.
+	rf := &Raft{Log: log}
+	if next := rf.nextIndexForConflict(500, 3); next != lastIndexOfTerm(log, 3)+1 {
+		t.Fatalf("expected nextIndex just past leader's last entry of term 3, got %v", next)
+	}
+	if next := rf.nextIndexForConflict(500, 10000); next != 500 {
+		t.Fatalf("expected nextIndex to fall back to ConflictIndex, got %v", next)
+	}
+	if next := rf.nextIndexForConflict(5000, -1); next != len(log) {
+		t.Fatalf("expected nextIndex capped at log length, got %v", next)
+	}
+}
+
+func BenchmarkConflictHint(b *testing.B) {
+	for _, n := range []int{100000, 1000000} {
+		rf := &Raft{Log: makeTermLog(n, 10)}
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rf.conflictHint(1 + i%n)
+			}
+		})
+	}
+}
+
+func BenchmarkNextIndexForConflict(b *testing.B) {
+	for _, n := range []int{100000, 1000000} {
+		rf := &Raft{Log: makeTermLog(n, 10)}
+		lastTerm := rf.Log[n].Term
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rf.nextIndexForConflict(n/2, 1+i%lastTerm)
+			}
+		})
+	}
+}
+
+func TestCommitIndexMonotonic2C(t *testing.T) {
+	servers := 5
+	cfg := make_config(t, servers, true)
+	defer cfg.cleanup()
+
+	cfg.setlongreordering(true)
+
+	fmt.Printf("Test (2C): commitIndex never decreases under long reordering ...\n")
+
+	// watch every server's commitIndex while the cluster churns.
+	var stop int32
+	var violation atomic.Value
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		last := make([]int, servers)
+		for atomic.LoadInt32(&stop) == 0 {
+			for i := 0; i < servers; i++ {
+				rf := cfg.rafts[i]
+				rf.mu.Lock()
+				ci := rf.commitIndex
+				rf.mu.Unlock()
+				if ci < last[i] {
+					violation.Store(fmt.Sprintf("server %v commitIndex went from %v to %v", i, last[i], ci))
+				}
+				last[i] = ci
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for iters := 0; iters < 100; iters++ {
+		for i := 0; i < servers; i++ {
+			cfg.rafts[i].Start(rand.Int() % 10000)
+		}
+
+		if (rand.Int() % 1000) < 200 {
+			cfg.disconnect(rand.Int() % servers)
+		}
+		if (rand.Int() % 1000) < 200 {
+			cfg.connect(rand.Int() % servers)
+		}
+
+		time.Sleep(time.Duration(rand.Int()%50) * time.Millisecond)
+	}
+
+	for i := 0; i < servers; i++ {
+		cfg.connect(i)
+	}
+	cfg.one(rand.Int()%10000, servers)
+
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	if v := violation.Load(); v != nil {
+		t.Fatalf("%v", v)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestSessionDedup2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): client session retries apply once ...\n")
+
+	first := cfg.one(101, servers)
+
+	// a client that retries the same seq gets a second log entry,
+	// but every server must apply it as a duplicate of the first.
+	leader := cfg.checkOneLeader()
+	clientId := nrand()
+	index1, _, ok1 := cfg.rafts[leader].StartSession(clientId, 1, 102)
+	index2, _, ok2 := cfg.rafts[leader].StartSession(clientId, 1, 102)
+	if !ok1 || !ok2 {
+		t.Fatalf("leader rejected StartSession()")
+	}
+	cfg.wait(index2, servers, -1)
+
+	cfg.mu.Lock()
+	for i := 0; i < servers; i++ {
+		if _, dup := cfg.dups[i][index1]; dup {
+			t.Fatalf("server %v treated the first attempt at %v as a duplicate", i, index1)
+		}
+		if cfg.dups[i][index2] != index1 {
+			t.Fatalf("server %v did not deduplicate retry at %v (dups %v)", i, index2, cfg.dups[i])
+		}
+	}
+	cfg.mu.Unlock()
+
+	// one() re-submits with the same seq, so it always reports
+	// the index at which the command first took effect.
+	if index := cfg.one(103, servers); index <= first {
+		t.Fatalf("one() returned index %v, expected > %v", index, first)
+	}
+
+	// idle sessions expire, based on timestamps in the log.
+	st := MakeSessionTable(time.Second)
+	st.Apply(1, LogEntry{ClientId: 7, Seq: 1, Timestamp: 0})
+	if dup, _ := st.Apply(2, LogEntry{ClientId: 7, Seq: 1, Timestamp: int64(time.Millisecond)}); !dup {
+		t.Fatalf("expected retry within expiry to be a duplicate")
+	}
+	st.Apply(3, LogEntry{ClientId: 8, Seq: 1, Timestamp: int64(3 * time.Second)})
+	if len(st.Sessions()) != 1 || st.Sessions()[0].ClientId != 8 {
+		t.Fatalf("expected idle session 7 to expire, have %v", st.Sessions())
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+type testCommand struct {
+	Op  string
+	Arg int
+}
+
+// comparable as a type, but == panics when Args holds a slice.
+type testNested struct {
+	Args interface{}
+}
+
+func TestPayloadCommands2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): agreement on non-int commands ...\n")
+
+	labrpc.Register(testCommand{})
+	labrpc.Register(testNested{})
+	labrpc.Register([]int{})
+
+	cfg.one(Payload("hello"), servers)
+	cfg.one(Payload(`{"op":"put","key":"k"}`), servers)
+	cfg.one(Payload([]byte{0, 1, 2, 255}), servers)
+	cfg.one(testNested{[]int{1, 2, 3}}, servers)
+	cfg.one(testCommand{"inc", 7}, servers)
+	index := cfg.one(101, servers)
+
+	// commands survive persistence and restart.
+	for i := 0; i < servers; i++ {
+		cfg.start1(i)
+	}
+	for i := 0; i < servers; i++ {
+		cfg.connect(i)
+	}
+	cfg.one(Payload("after restart"), servers)
+
+	if n, cmd := cfg.nCommitted(index - 1); n != servers || cmd != (testCommand{"inc", 7}) {
+		t.Fatalf("expected testCommand at %v on all servers, got %v on %v", index-1, cmd, n)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestMultiRaftGroups2A(t *testing.T) {
+	servers := 3
+	groups := 4
+	cfg := make_multi_config(t, servers, groups, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2A): multi-raft groups sharing one transport ...\n")
+
+	leaders := cfg.checkLeaders()
+	for g := 0; g < groups; g++ {
+		cfg.one(g, 100+g, servers)
+	}
+
+	// groups are independent: each has its own log.
+	for g := 0; g < groups; g++ {
+		if nd, cmd := cfg.nCommitted(g, 1); nd != servers || cmd != 100+g {
+			t.Fatalf("group %d index 1: %d servers committed %v", g, nd, cmd)
+		}
+	}
+
+	// with more groups than servers some server leads several
+	// groups, and its heartbeats to the same follower are coalesced.
+	var heartbeats, batches int64
+	for i := 0; i < servers; i++ {
+		stats := cfg.servers[i].Stats()
+		heartbeats += stats.Heartbeats
+		batches += stats.Batches
+	}
+	if heartbeats == 0 || batches >= heartbeats {
+		t.Fatalf("expected heartbeats to be batched, got %d batches for %d heartbeats", batches, heartbeats)
+	}
+
+	// every group keeps working with one server down.
+	cfg.disconnect(leaders[0])
+	cfg.checkLeaders()
+	for g := 0; g < groups; g++ {
+		cfg.one(g, 200+g, servers-1)
+	}
+	cfg.connect(leaders[0])
+	for g := 0; g < groups; g++ {
+		cfg.one(g, 300+g, servers)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestForwardToLeader2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): followers forward proposals to the leader ...\n")
+
+	leader := cfg.checkOneLeader()
+	follower := (leader + 1) % servers
+	cfg.one(101, servers)
+
+	// without forwarding, a follower only points at the leader.
+	p := cfg.rafts[follower].Propose(NoClient, 0, 102)
+	if p.Err != ErrNotLeader || p.LeaderId != leader {
+		t.Fatalf("expected ErrNotLeader with leader %d, got %+v", leader, p)
+	}
+
+	cfg.setforwarding(true)
+	p = cfg.rafts[follower].Propose(NoClient, 0, 103)
+	if p.Err != "" || !p.Forwarded {
+		t.Fatalf("expected forwarded proposal, got %+v", p)
+	}
+	term, _ := cfg.rafts[leader].GetState()
+	if p.Term != term {
+		t.Fatalf("expected leader's term %d, got %d", term, p.Term)
+	}
+	if cmd := cfg.wait(p.Index, servers, -1); cmd != 103 {
+		t.Fatalf("expected 103 at index %d, got %v", p.Index, cmd)
+	}
+
+	// a follower cut off from a majority stops knowing the leader.
+	cfg.disconnect(leader)
+	cfg.disconnect((leader + 2) % servers)
+	time.Sleep(RaftElectionTimeout)
+	p = cfg.rafts[follower].Propose(NoClient, 0, 104)
+	if p.Err != ErrNoLeader {
+		t.Fatalf("expected ErrNoLeader, got %+v", p)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestClusterSubmit2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): cluster submit follows leader hints ...\n")
+
+	leader := cfg.checkOneLeader()
+	p, got, attempts := cfg.submit(NoClient, 0, 101, 2*time.Second)
+	if p.Err != "" || got != leader {
+		t.Fatalf("expected leader %d to accept, got %d %+v", leader, got, p)
+	}
+	// at most one wrong guess before the hint points at the leader.
+	if len(attempts) > 2 {
+		t.Fatalf("expected to reach the leader within 2 attempts, got %+v", attempts)
+	}
+	cfg.wait(p.Index, servers, -1)
+
+	// the hint now points at a dead leader; submit retries until
+	// the remaining servers elect a new one.
+	cfg.disconnect(leader)
+	p, got, attempts = cfg.submit(NoClient, 0, 102, 5*time.Second)
+	if p.Err != "" || got == leader {
+		t.Fatalf("expected a new leader to accept, got %d %+v", got, p)
+	}
+	if attempts[0].Number != leader || attempts[0].Err != "disconnected" {
+		t.Fatalf("expected the old leader to be tried first, got %+v", attempts[0])
+	}
+	cfg.wait(p.Index, servers-1, -1)
+
+	// without a majority no leader can be found.
+	cfg.disconnect(got)
+	p, _, _ = cfg.submit(NoClient, 0, 103, RaftElectionTimeout)
+	if p.Err != "timeout" {
+		t.Fatalf("expected timeout without a majority, got %+v", p)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+// run a fresh cluster on a simulated clock seeded with seed, firing
+// one timer at a time, until a leader is elected. returns the leader,
+// its term and the simulated time it took.
+func simulateElection(t *testing.T, seed int64) (int, int, time.Duration) {
+	clock := labrpc.MakeSimClock(seed)
+	cfg := make_config_clock(t, 3, false, clock)
+	defer cfg.cleanup()
+
+	start := clock.Now()
+	for steps := 0; steps < 1000; steps++ {
+		// let the servers react to the last timer before the next one.
+		time.Sleep(5 * time.Millisecond)
+		for i := 0; i < cfg.n; i++ {
+			if term, isLeader := cfg.rafts[i].GetState(); isLeader {
+				return i, term, clock.Now().Sub(start)
+			}
+		}
+		clock.Step()
+	}
+	t.Fatalf("seed %d: no leader after 1000 simulated timers", seed)
+	return -1, -1, 0
+}
+
+func TestSimulatedClock2A(t *testing.T) {
+	fmt.Printf("Test (2A): simulated clock ...\n")
+
+	clock := labrpc.MakeSimClock(1)
+	cfg := make_config_clock(t, 3, false, clock)
+	if seed := cfg.net.Seed(); seed != 1 {
+		t.Fatalf("the network is seeded with %d, not the clock's seed", seed)
+	}
+
+	// with the clock stopped, no election timeout can fire.
+	time.Sleep(RaftElectionTimeout)
+	cfg.checkNoLeader()
+	if term := cfg.checkTerms(); term != 0 {
+		t.Fatalf("expected term 0 while the clock is stopped, got %d", term)
+	}
+
+	// advancing past the largest election timeout elects a leader.
+	for i := 0; i < 50; i++ {
+		clock.Advance(10 * time.Millisecond)
+		time.Sleep(2 * time.Millisecond)
+	}
+	cfg.checkOneLeader()
+	cfg.cleanup()
+
+	// the same seed elects the same leader, at the same simulated time.
+	for _, seed := range []int64{1, 2, 3} {
+		leader1, term1, elapsed1 := simulateElection(t, seed)
+		leader2, term2, elapsed2 := simulateElection(t, seed)
+		if leader1 != leader2 || term1 != term2 || elapsed1 != elapsed2 {
+			t.Fatalf("seed %d: replay differs: leader %d/%d term %d/%d after %v/%v",
+				seed, leader1, leader2, term1, term2, elapsed1, elapsed2)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPauseResume2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): a paused leader resumes stale ...\n")
+
+	cfg.one(101, servers)
+	leader := cfg.checkOneLeader()
+	term1, _ := cfg.rafts[leader].GetState()
+
+	// the frozen leader keeps its links, but neither times out
+	// nor learns about the new term.
+	cfg.pause(leader)
+	time.Sleep(2 * RaftElectionTimeout)
+	if term, isLeader := cfg.rafts[leader].GetState(); term != term1 || !isLeader {
+		t.Fatalf("paused leader changed state: term %d leader %v", term, isLeader)
+	}
+
+	// the others elect a new leader and carry on without it.
+	cfg.one(102, servers-1)
+	leader2 := cfg.checkOneLeader()
+	if leader2 == leader {
+		t.Fatalf("paused server is still the only leader")
+	}
+
+	// resumed, the stale leader steps down and catches up.
+	cfg.resume(leader)
+	cfg.one(103, servers)
+	if _, isLeader := cfg.rafts[leader].GetState(); isLeader {
+		t.Fatalf("stale leader did not step down after resuming")
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestClockSkew2A(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2A): clock skew ...\n")
+
+	// a leader whose clock runs slow sends heartbeats too rarely,
+	// and the followers depose it.
+	leader1 := cfg.checkOneLeader()
+	term1, _ := cfg.rafts[leader1].GetState()
+	cfg.setrate(leader1, 0.05)
+
+	time.Sleep(2 * RaftElectionTimeout)
+	leader2 := cfg.checkOneLeader()
+	if term2, _ := cfg.rafts[leader2].GetState(); leader2 == leader1 || term2 <= term1 {
+		t.Fatalf("slow leader %d was not replaced (leader %d term %d)", leader1, leader2, term2)
+	}
+
+	// a follower whose clock runs fast times out before the next
+	// heartbeat, and takes over.
+	cfg.setrate(leader1, 1)
+	fast := (leader2 + 1) % servers
+	cfg.setrate(fast, 10)
+	time.Sleep(2 * RaftElectionTimeout)
+	if leader3 := cfg.checkOneLeader(); leader3 != fast {
+		t.Fatalf("expected fast server %d to become leader, got %d", fast, leader3)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestForceNewCluster2C(t *testing.T) {
+	servers := 5
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2C): force a new cluster from a surviving minority ...\n")
+
+	cfg.one(101, servers)
+
+	// a majority is lost for good.
+	leader := cfg.checkOneLeader()
+	survivors := []int{(leader + 1) % servers, (leader + 2) % servers}
+	for i := 0; i < servers; i++ {
+		if i != survivors[0] && i != survivors[1] {
+			cfg.crash1(i)
+		}
+	}
+
+	// the minority can never elect a leader on its own.
+	time.Sleep(2 * RaftElectionTimeout)
+	cfg.checkNoLeader()
+
+	if _, err := cfg.forceNewCluster([]int{survivors[0], survivors[1], survivors[0]}); err == nil {
+		t.Fatalf("force new cluster accepted a duplicate member")
+	}
+	if _, err := cfg.forceNewCluster([]int{survivors[0], leader}); err == nil {
+		t.Fatalf("force new cluster accepted a crashed member")
+	}
+	cfg.checkNoLeader()
+	if rewritten, err := cfg.forceNewCluster(survivors); err != nil || len(rewritten) != 2 {
+		t.Fatalf("force new cluster failed: %v, rewritten %v", err, rewritten)
+	}
+	leader2 := cfg.checkOneLeader()
+	if leader2 != survivors[0] && leader2 != survivors[1] {
+		t.Fatalf("leader %d is not a survivor", leader2)
+	}
+	index := cfg.one(102, 2)
+	if index != 2 {
+		t.Fatalf("expected the new cluster to keep the log, got index %d", index)
+	}
+
+	// a removed server that is still connected could form a
+	// cluster of its own with the old configuration.
+	if _, err := cfg.forceNewCluster(survivors[:1]); err == nil {
+		t.Fatalf("force new cluster accepted a connected non-member")
+	}
+
+	// a single survivor is a majority of itself.
+	cfg.crash1(survivors[1])
+	if _, err := cfg.forceNewCluster(survivors[:1]); err != nil {
+		t.Fatalf("force new cluster failed: %v", err)
+	}
+	cfg.one(103, 1)
+
+	// the new configuration survives a restart.
+	cfg.start1(survivors[0])
+	cfg.connect(survivors[0])
+	cfg.one(104, 1)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestBackupRestore2C(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2C): backup and restore persisted state ...\n")
+
+	cfg.one(101, servers)
+	cfg.one(102, servers)
+	cfg.one(103, servers)
+
+	// save the cluster, through the archive encoding.
+	archives := make([]*Archive, servers)
+	for i := 0; i < servers; i++ {
+		a, err := cfg.backup(i)
+		if err != nil {
+			t.Fatalf("backup %d: %v", i, err)
+		}
+		if a.Node != i || a.Servers != servers || a.LastIndex != 3 {
+			t.Fatalf("archive %d has node %d servers %d last index %d", i, a.Node, a.Servers, a.LastIndex)
+		}
+		var buf bytes.Buffer
+		if err := WriteArchive(&buf, a); err != nil {
+			t.Fatalf("write archive: %v", err)
+		}
+		if archives[i], err = ReadArchive(&buf); err != nil {
+			t.Fatalf("read archive: %v", err)
+		}
+	}
+
+	// a damaged archive is refused.
+	bad := *archives[0]
+	bad.RaftState = append([]byte(nil), bad.RaftState...)
+	bad.RaftState[len(bad.RaftState)-1] ^= 1
+	if err := bad.Validate(); err == nil {
+		t.Fatalf("damaged archive passed validation")
+	}
+
+	cfg.one(104, servers)
+
+	// a lagging server restored from its archive catches up.
+	cfg.disconnect(0)
+	cfg.one(105, servers-1)
+	if err := cfg.restore(0, archives[0]); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	cfg.connect(0)
+	cfg.one(106, servers)
+
+	// loading the saved cluster state rewinds it to index 3.
+	if err := cfg.restoreAll(archives); err != nil {
+		t.Fatalf("restore all: %v", err)
+	}
+	if index := cfg.one(107, servers); index != 4 {
+		t.Fatalf("expected index 4 after restoring the cluster, got %d", index)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestStaleRead2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): bounded-staleness reads ...\n")
+
+	index := cfg.one(101, servers)
+	leader := cfg.checkOneLeader()
+	follower := (leader + 1) % servers
+
+	// a heartbeat or two carries the read proof to the followers.
+	time.Sleep(RaftElectionTimeout / 5)
+	for _, i := range []int{leader, follower} {
+		rs, v := cfg.staleRead(i, RaftElectionTimeout/2)
+		if rs.Err != "" {
+			t.Fatalf("read on %d failed: %v", i, rs.Err)
+		}
+		if rs.Index < index || !sameCommand(v, 101) {
+			t.Fatalf("read on %d at index %d returned %v, expected 101 at index %d", i, rs.Index, v, index)
+		}
+		if rs.Leader != (i == leader) {
+			t.Fatalf("read on %d reported leader %v", i, rs.Leader)
+		}
+	}
+
+	// a partitioned follower keeps serving reads, but its
+	// staleness bound grows.
+	cfg.disconnect(follower)
+	time.Sleep(RaftElectionTimeout / 2)
+	if rs, _ := cfg.staleRead(follower, RaftElectionTimeout/5); rs.Err != ErrTooStale {
+		t.Fatalf("partitioned follower read: expected %q, got %q", ErrTooStale, rs.Err)
+	}
+	if rs, _ := cfg.staleRead(follower, 0); rs.Err != "" || rs.Staleness < RaftElectionTimeout/2 {
+		t.Fatalf("unbounded read on partitioned follower: err %q staleness %v", rs.Err, rs.Staleness)
+	}
+	cfg.connect(follower)
+
+	// so does a partitioned leader, once the majority stops
+	// confirming it.
+	cfg.disconnect(leader)
+	index2 := cfg.one(102, servers-1)
+	time.Sleep(RaftElectionTimeout / 5)
+	if rs, _ := cfg.staleRead(leader, RaftElectionTimeout/5); rs.Err != ErrTooStale {
+		t.Fatalf("partitioned leader read: expected %q, got %q", ErrTooStale, rs.Err)
+	}
+	leader2 := cfg.checkOneLeader()
+	for i := 0; i < servers; i++ {
+		if i == leader {
+			continue
+		}
+		rs, v := cfg.staleRead(i, RaftElectionTimeout/2)
+		if rs.Err != "" || rs.Index < index2 || !sameCommand(v, 102) {
+			t.Fatalf("read on %d (leader %d): err %q index %d value %v", i, leader2, rs.Err, rs.Index, v)
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestEntryMetadata2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): log entries record proposer, client and times ...\n")
+
+	leader := cfg.checkOneLeader()
+	follower := (leader + 1) % servers
+
+	p1 := cfg.rafts[leader].Propose(7, 1, 101)
+	cfg.setforwarding(true)
+	p2 := cfg.rafts[follower].Propose(NoClient, 0, 102)
+	if p1.Err != "" || p2.Err != "" {
+		t.Fatalf("proposals failed: %+v %+v", p1, p2)
+	}
+	cfg.wait(p2.Index, servers, -1)
+	// applied times are recorded once the applier takes the entry.
+	time.Sleep(50 * time.Millisecond)
+
+	for i := 0; i < servers; i++ {
+		rf := cfg.rafts[i]
+		rf.mu.Lock()
+		e1, e2 := rf.Log[p1.Index], rf.Log[p2.Index]
+		rf.mu.Unlock()
+		if e1.Proposer != leader || e1.ClientId != 7 {
+			t.Fatalf("server %d: entry %d has proposer %d client %d, expected %d and 7", i, p1.Index, e1.Proposer, e1.ClientId, leader)
+		}
+		if e2.Proposer != follower || e2.ClientId != NoClient {
+			t.Fatalf("server %d: forwarded entry %d has proposer %d client %d, expected %d", i, p2.Index, e2.Proposer, e2.ClientId, follower)
+		}
+		times := rf.EntryTimes()
+		for index, e := range map[int]LogEntry{p1.Index: e1, p2.Index: e2} {
+			if len(times) <= index {
+				t.Fatalf("server %d: no times for entry %d", i, index)
+			}
+			tm := times[index]
+			if tm.Committed < e.Timestamp || tm.Applied < tm.Committed {
+				t.Fatalf("server %d: entry %d proposed %d, committed %d, applied %d",
+					i, index, e.Timestamp, tm.Committed, tm.Applied)
+			}
+		}
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestProposalLimits2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): proposal size limits and backpressure ...\n")
+
+	cfg.one(101, servers)
+	leader := cfg.checkOneLeader()
+	cfg.setlimits(Limits{MaxEntryBytes: 10, MaxUncommittedEntries: 3})
+
+	if p := cfg.rafts[leader].Propose(NoClient, 0, Payload("0123456789a")); p.Err != ErrTooLarge || p.Index != -1 {
+		t.Fatalf("expected %q for a large entry, got %+v", ErrTooLarge, p)
+	}
+	// a command gob can't encode has no known size.
+	if p := cfg.rafts[leader].Propose(NoClient, 0, func() {}); p.Err != ErrTooLarge || p.Index != -1 {
+		t.Fatalf("expected %q for an entry gob can't encode, got %+v", ErrTooLarge, p)
+	}
+
+	// with no follower reachable nothing commits, and the leader
+	// refuses more than 3 uncommitted entries.
+	cfg.disconnect((leader + 1) % servers)
+	cfg.disconnect((leader + 2) % servers)
+	for i := 0; i < 3; i++ {
+		if p := cfg.rafts[leader].Propose(NoClient, 0, Payload(fmt.Sprint(102+i))); p.Err != "" {
+			t.Fatalf("proposal %d refused: %+v", i, p)
+		}
+	}
+	if p := cfg.rafts[leader].Propose(NoClient, 0, Payload("105")); p.Err != ErrOverloaded || p.Index != -1 {
+		t.Fatalf("expected %q, got %+v", ErrOverloaded, p)
+	}
+	if index, _, isLeader := cfg.rafts[leader].Start(Payload("106")); index != -1 || !isLeader {
+		t.Fatalf("expected Start() to refuse with index -1, got index %d leader %v", index, isLeader)
+	}
+
+	// a byte cap counts the whole uncommitted tail.
+	cfg.setlimits(Limits{MaxUncommittedBytes: 20})
+	if p := cfg.rafts[leader].Propose(NoClient, 0, Payload("0123456789")); p.Err != "" {
+		t.Fatalf("proposal refused: %+v", p)
+	}
+	if p := cfg.rafts[leader].Propose(NoClient, 0, Payload("0123456789")); p.Err != ErrOverloaded {
+		t.Fatalf("expected %q, got %+v", ErrOverloaded, p)
+	}
+
+	// once the tail commits, proposals are accepted again.
+	cfg.connect((leader + 1) % servers)
+	cfg.connect((leader + 2) % servers)
+	cfg.one(107, servers)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestReplicationProgress2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): per-follower replication progress ...\n")
+
+	index := cfg.one(101, servers)
+	leader := cfg.checkOneLeader()
+	lagging := (leader + 1) % servers
+
+	progress := func(server int) Progress {
+		for _, pr := range cfg.rafts[leader].Progress() {
+			if pr.Server == server {
+				return pr
+			}
+		}
+		t.Fatalf("leader %d has no progress for %d", leader, server)
+		return Progress{}
+	}
+	// the leader may append more entries (e.g. a retried proposal)
+	// after one() returns, so wait for server's progress to reach
+	// state with a match of at least index; a follower that keeps
+	// up must catch up with the leader's last entry.
+	check := func(server int, state string, index int) {
+		var pr Progress
+		for iters := 0; iters < 100; iters++ {
+			pr = progress(server)
+			last := cfg.rafts[leader].Status().LogLength - 1
+			if pr.State == state && pr.Match >= index &&
+				(state != ProgressReplicate || pr.Match == last) {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatalf("progress of %d: expected %s with match >= %d, got %+v", server, state, index, pr)
+	}
+	for i := 0; i < servers; i++ {
+		if i != leader {
+			check(i, ProgressReplicate, index)
+		}
+	}
+	if prs := cfg.rafts[lagging].Progress(); prs != nil {
+		t.Fatalf("follower reported progress %+v", prs)
+	}
+
+	// an unreachable follower falls back to probing from its
+	// last known match, once its RPCs time out.
+	cfg.disconnect(lagging)
+	index2 := cfg.one(102, servers-1)
+	check((leader+2)%servers, ProgressReplicate, index2)
+	check(lagging, ProgressProbe, index)
+
+	cfg.connect(lagging)
+	index3 := cfg.one(103, servers)
+	if l := cfg.checkOneLeader(); l == leader {
+		check(lagging, ProgressReplicate, index3)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestPartition2B(t *testing.T) {
+	servers := 5
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): named partitions ...\n")
+
+	cfg.one(101, servers)
+	leader := cfg.checkOneLeader()
+
+	// the leader ends up on the minority side.
+	minority := []int{leader, (leader + 1) % servers}
+	var majority []int
+	for i := 2; i < servers; i++ {
+		majority = append(majority, (leader+i)%servers)
+	}
+	if err := cfg.partition(map[string][]int{"a": minority, "b": majority}); err != nil {
+		t.Fatalf("partition: %v", err)
+	}
+	if cfg.partitionOf(leader) != "a" || cfg.partitionOf(majority[0]) != "b" {
+		t.Fatalf("unexpected partitions %v", cfg.net.Partitions())
+	}
+
+	index, _, ok := cfg.rafts[leader].Start(102)
+	if !ok {
+		t.Fatalf("leader rejected Start()")
+	}
+
+	// the majority elects a leader of its own and commits.
+	leader2 := -1
+	for iters := 0; iters < 20 && leader2 < 0; iters++ {
+		time.Sleep(250 * time.Millisecond)
+		for _, i := range majority {
+			if _, isLeader := cfg.rafts[i].GetState(); isLeader {
+				leader2 = i
+			}
+		}
+	}
+	if leader2 < 0 {
+		t.Fatalf("the majority elected no leader")
+	}
+	index2, _, ok := cfg.rafts[leader2].Start(103)
+	if !ok || index2 != index {
+		t.Fatalf("new leader: Start() returned index %d ok %v, expected index %d", index2, ok, index)
+	}
+	if cmd := cfg.wait(index2, len(majority), -1); cmd != 103 {
+		t.Fatalf("index %d committed %v, expected 103", index2, cmd)
+	}
+	if nd, _ := cfg.nCommitted(index); nd != len(majority) {
+		t.Fatalf("%d servers committed index %d across the partition", nd, index)
+	}
+
+	// healing needs one call; the old leader's entry is replaced.
+	cfg.heal()
+	cfg.one(104, servers)
+	if nd, cmd := cfg.nCommitted(index); nd != servers || cmd != 103 {
+		t.Fatalf("after heal %d servers committed %v at index %d", nd, cmd, index)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestLinkConditions2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): per-link delay, loss and duplication ...\n")
+
+	cfg.one(101, servers)
+
+	// a flaky replica: everything to and from it is jittery,
+	// often lost, and requests are often executed twice.
+	flaky := (cfg.checkOneLeader() + 1) % servers
+	flakyLink := labrpc.LinkConfig{MaxDelay: 30 * time.Millisecond, Drop: 0.2, Duplicate: 0.2}
+	for i := 0; i < servers; i++ {
+		if i != flaky {
+			if err := cfg.setlink(i, flaky, flakyLink); err != nil {
+				t.Fatalf("setlink: %v", err)
+			}
+			cfg.setlink(flaky, i, flakyLink)
+		}
+	}
+	if err := cfg.setlink(0, servers, flakyLink); err == nil {
+		t.Fatalf("setlink accepted a server that does not exist")
+	}
+	for i := 0; i < 10; i++ {
+		cfg.one(200+i, servers)
+	}
+
+	// one slow link in both directions between two other servers.
+	a, b := (flaky+1)%servers, (flaky+2)%servers
+	slow := labrpc.LinkConfig{MinDelay: 50 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+	cfg.setlink(a, b, slow)
+	cfg.setlink(b, a, slow)
+	for i := 0; i < 10; i++ {
+		cfg.one(300+i, servers)
+	}
+
+	for i := 0; i < servers; i++ {
+		for j := 0; j < servers; j++ {
+			cfg.clearlink(i, j)
+		}
+	}
+	if links := cfg.net.Links(); len(links) != 0 {
+		t.Fatalf("links after clearing: %+v", links)
+	}
+	cfg.one(400, servers)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestOneWayLeader2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): leader that can send but not receive ...\n")
+
+	cfg.one(101, servers)
+	leader := cfg.checkOneLeader()
+	term, _ := cfg.rafts[leader].GetState()
+
+	// the leader's heartbeats still reach the followers, so they
+	// never start an election, but it never hears an ack.
+	cfg.cutincoming(leader)
+	index, _, ok := cfg.rafts[leader].Start(102)
+	if !ok {
+		t.Fatalf("leader rejected Start()")
+	}
+	time.Sleep(2 * RaftElectionTimeout)
+	for i := 0; i < servers; i++ {
+		t1, isLeader := cfg.rafts[i].GetState()
+		if t1 != term || isLeader != (i == leader) {
+			t.Fatalf("server %d: term %d leader %v; expected term %d with leader %d", i, t1, isLeader, term, leader)
+		}
+	}
+	if nd, _ := cfg.nCommitted(index); nd != 0 {
+		t.Fatalf("%d servers committed without acks reaching the leader", nd)
+	}
+
+	cfg.restorelinks(leader)
+	if cmd := cfg.wait(index, servers, -1); cmd != 102 {
+		t.Fatalf("index %d committed %v, expected 102", index, cmd)
+	}
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestOneWayFollower2B(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	fmt.Printf("Test (2B): follower with a one-way link failure ...\n")
+
+	cfg.one(101, servers)
+	leader := cfg.checkOneLeader()
+	follower := (leader + 1) % servers
+	other := (leader + 2) % servers
+
+	// a follower that receives but cannot send still learns new
+	// entries and the commit index from heartbeats.
+	cfg.cutoutgoing(follower)
+	term, _ := cfg.rafts[leader].GetState()
+	for i := 0; i < 5; i++ {
+		cfg.one(200+i, servers)
+	}
+	if t1, _ := cfg.rafts[follower].GetState(); t1 != term {
+		t.Fatalf("a follower that can only receive moved to term %d from %d", t1, term)
+	}
+	cfg.restorelinks(follower)
+
+	// a follower that can send but not receive keeps timing out.
+	// its RequestVotes arrive and push the others to new terms.
+	cfg.cutincoming(follower)
+	term, _ = cfg.rafts[other].GetState()
+	time.Sleep(2 * RaftElectionTimeout)
+	if t1, _ := cfg.rafts[other].GetState(); t1 < term+2 {
+		t.Fatalf("term only moved from %d to %d while a follower could not receive", term, t1)
+	}
+
+	cfg.restorelinks(follower)
+	cfg.one(300, servers)
+
+	fmt.Printf("  ... Passed\n")
+}
+
+func TestInvariantChecks(t *testing.T) {
+	makeChecked := func() *Raft {
+		rf := newRaft(make([]*labrpc.ClientEnd, 3), 0, MakePersister(), make(chan ApplyMsg), labrpc.MakeRealClock())
+		rf.SetChecked(true)
+		return rf
+	}
+
+	// a leader that truncates its own log stops.
+	rf := makeChecked()
+	rf.mu.Lock()
+	rf.CurrentTerm = 1
+	rf.turnLeader()
+	rf.Log = append(rf.Log, LogEntry{Term: 1}, LogEntry{Term: 1})
+	rf.check("test")
+	if rf.violation != "" {
+		t.Fatalf("unexpected violation: %v", rf.violation)
+	}
+	rf.Log = rf.Log[:2]
+	rf.check("test")
+	rf.mu.Unlock()
+	if v := rf.Violation(); !strings.Contains(v, "truncated") {
+		t.Fatalf("expected a truncation report, got %q", v)
+	}
+	rf.Resume()
+	if !rf.isDone() || !rf.Paused() {
+		t.Fatalf("a node with a broken invariant kept running")
+	}
+	rf.Kill()
+
+	rf = makeChecked()
+	rf.mu.Lock()
+	rf.Log = append(rf.Log, LogEntry{Term: 1}, LogEntry{Term: 1})
+	rf.commitTo(2)
+	rf.check("test")
+	rf.commitIndex = 1
+	rf.check("test")
+	rf.mu.Unlock()
+	if v := rf.Violation(); !strings.Contains(v, "commitIndex decreased") {
+		t.Fatalf("expected a commitIndex report, got %q", v)
+	}
+
+	// votes are persisted before RequestVote returns.
+	rf = makeChecked()
+	args := RequestVoteArgs{Term: 1, CandidatId: 1}
+	reply := RequestVoteReply{}
+	rf.RequestVote(&args, &reply)
+	if !reply.VoteGranted || rf.Violation() != "" {
+		t.Fatalf("vote granted %v, violation %q", reply.VoteGranted, rf.Violation())
+	}
+	rf.mu.Lock()
+	rf.turnFollower(2, NoLeader)
+	rf.VotedFor = 2
+	rf.checkVote(&RequestVoteArgs{Term: 2, CandidatId: 2})
+	rf.mu.Unlock()
+	if v := rf.Violation(); !strings.Contains(v, "before it was persisted") {
+		t.Fatalf("expected an unpersisted vote report, got %q", v)
+	}
+}